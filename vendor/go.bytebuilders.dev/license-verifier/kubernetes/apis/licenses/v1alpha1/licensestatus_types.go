@@ -0,0 +1,84 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	kmapi "kmodules.xyz/client-go/apis/kmapi"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ResourceKindLicenseStatus = "LicenseStatus"
+	ResourceLicenseStatus     = "licensestatus"
+	ResourceLicenseStatuses   = "licensestatuses"
+)
+
+// LicenseCondition names the well-known Conditions the enforcer reconciles
+// onto a LicenseStatus object's .status.conditions.
+type LicenseCondition string
+
+const (
+	// LicenseConditionVerified is True when the license most recently
+	// fetched verified successfully.
+	LicenseConditionVerified LicenseCondition = "Verified"
+	// LicenseConditionNearExpiry is True once the license's NotAfter is
+	// within the enforcer's NearExpiryThreshold of now.
+	LicenseConditionNearExpiry LicenseCondition = "NearExpiry"
+	// LicenseConditionExpired is True once the license itself reports
+	// LicenseExpired.
+	LicenseConditionExpired LicenseCondition = "Expired"
+	// LicenseConditionUnreachable is True whenever the license reports
+	// LicenseUnknown: the enforcer couldn't fetch, read or parse the license
+	// (a JWT whose signature or cluster check fails counts as a parse
+	// failure), or couldn't look up the cluster UID. A license that parses
+	// but fails verifier.VerifyLicense or its validity window is Expired
+	// instead.
+	LicenseConditionUnreachable LicenseCondition = "Unreachable"
+)
+
+// LicenseStatus is a cluster-scoped object, one per product (named after
+// info.ProductName), that mirrors the license state every replica of that
+// product's operator serves from its own /appscode/license endpoint. It
+// gives cluster admins a `kubectl get licensestatus` view and lets
+// Prometheus scrape license conditions via kube-state-metrics instead of
+// requiring a request to every operator pod.
+type LicenseStatus struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status LicenseStatusStatus `json:"status,omitempty"`
+}
+
+// LicenseStatusStatus is the .status of a LicenseStatus object.
+type LicenseStatusStatus struct {
+	// License mirrors the same License the product's /appscode/license
+	// handler serves.
+	License License `json:"license,omitempty"`
+
+	// Conditions holds one entry per LicenseCondition the enforcer tracks;
+	// see reconcileLicenseStatus in the kubernetes package for how they're
+	// derived.
+	Conditions []kmapi.Condition `json:"conditions,omitempty"`
+}
+
+// LicenseStatusList is a list of LicenseStatus.
+type LicenseStatusList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LicenseStatus `json:"items"`
+}
@@ -0,0 +1,61 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import "sync/atomic"
+
+// FailurePolicy controls what handleLicenseVerificationFailure does once a
+// license has been failing verification for longer than GracePeriod.
+type FailurePolicy string
+
+const (
+	// FailurePolicyTerminate SIGINTs the process, the behavior this package
+	// has always had. It's the zero-value policy so existing callers that
+	// never set LicenseEnforcer.FailurePolicy keep today's behavior.
+	FailurePolicyTerminate FailurePolicy = ""
+
+	// FailurePolicyDegradedReadOnly leaves the process running but flips
+	// IsDegraded() to true, so admission webhooks and controllers can reject
+	// mutating operations on their own terms instead of being killed
+	// out from under an in-flight request.
+	FailurePolicyDegradedReadOnly FailurePolicy = "DegradedReadOnly"
+
+	// FailurePolicyEmitEventOnly never terminates or degrades the process;
+	// it only records the Warning Event, same as happens during GracePeriod.
+	FailurePolicyEmitEventOnly FailurePolicy = "EmitEventOnly"
+)
+
+// degraded is package-level because FailurePolicyDegradedReadOnly is meant
+// to be checked from webhooks and controllers that have no reference to the
+// LicenseEnforcer instance doing the verifying.
+var degraded int32
+
+// IsDegraded reports whether a LicenseEnforcer running with
+// FailurePolicyDegradedReadOnly has given up on its GracePeriod. Admission
+// webhooks and controllers can check this to reject mutating operations
+// while still serving reads.
+func IsDegraded() bool {
+	return atomic.LoadInt32(&degraded) == 1
+}
+
+func setDegraded(v bool) {
+	var i int32
+	if v {
+		i = 1
+	}
+	atomic.StoreInt32(&degraded, i)
+}
@@ -0,0 +1,140 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"go.bytebuilders.dev/license-verifier/info"
+	"go.bytebuilders.dev/license-verifier/kubernetes/apis/licenses/v1alpha1"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// licenseClaims is the set of standard and custom JWT claims a license
+// token carries. The standard claims map onto the same v1alpha1.License
+// fields the PEM path derives from x509 certificate fields, so callers of
+// LoadLicense can't tell which encoding produced the result.
+type licenseClaims struct {
+	jwt.Claims
+	Products []string `json:"products"`
+	Clusters []string `json:"clusters"`
+}
+
+// parseJWTLicense verifies data as a compact JWS token signed against
+// info.LicenseCA (RS256 or ES256) and maps its claims onto a v1alpha1.License:
+// iat/nbf -> NotBefore, exp -> NotAfter, jti -> ID, sub/aud -> the cluster
+// this license was issued for, products -> License.Products and
+// clusters -> License.Clusters.
+func (le *LicenseEnforcer) parseJWTLicense(data []byte) (v1alpha1.License, error) {
+	token, err := jwt.ParseSigned(string(data))
+	if err != nil {
+		return v1alpha1.License{}, fmt.Errorf("failed to parse license as JWT, reason: %v", err)
+	}
+
+	if err := ensureSupportedJWTAlgorithm(token); err != nil {
+		return v1alpha1.License{}, err
+	}
+
+	signingKey, err := licenseCASigningKey()
+	if err != nil {
+		return v1alpha1.License{}, err
+	}
+
+	var claims licenseClaims
+	if err := token.Claims(signingKey, &claims); err != nil {
+		return v1alpha1.License{}, fmt.Errorf("failed to verify license JWT signature, reason: %v", err)
+	}
+
+	if !claims.Claims.AnyAudience(le.opts.ClusterUID) && claims.Claims.Subject != le.opts.ClusterUID {
+		return v1alpha1.License{}, fmt.Errorf("license JWT is not issued for cluster %q", le.opts.ClusterUID)
+	}
+
+	license := v1alpha1.License{
+		Issuer:        claims.Claims.Issuer,
+		ID:            claims.Claims.ID,
+		Products:      claims.Products,
+		Clusters:      claims.Clusters,
+		LicenseFormat: v1alpha1.LicenseFormatJWT,
+	}
+	if claims.Claims.NotBefore != nil {
+		license.NotBefore = &metav1.Time{Time: claims.Claims.NotBefore.Time()}
+	} else if claims.Claims.IssuedAt != nil {
+		license.NotBefore = &metav1.Time{Time: claims.Claims.IssuedAt.Time()}
+	}
+	if claims.Claims.Expiry != nil {
+		license.NotAfter = &metav1.Time{Time: claims.Claims.Expiry.Time()}
+	}
+	return license, nil
+}
+
+// checkJWTLicenseWindow returns an error unless the current time falls
+// within license's NotBefore/NotAfter, the nbf/exp window parseJWTLicense
+// maps but does not enforce.
+func checkJWTLicenseWindow(license v1alpha1.License) error {
+	now := time.Now()
+	if license.NotBefore != nil && now.Before(license.NotBefore.Time) {
+		return fmt.Errorf("license JWT is not valid before %s", license.NotBefore.Time)
+	}
+	if license.NotAfter != nil && now.After(license.NotAfter.Time) {
+		return fmt.Errorf("license JWT expired at %s", license.NotAfter.Time)
+	}
+	return nil
+}
+
+// ensureSupportedJWTAlgorithm rejects anything but the two algorithms the
+// license issuer signs with; accepting "none" or a caller-chosen symmetric
+// algorithm here would let a forged token verify against whatever secret
+// the forger picked.
+func ensureSupportedJWTAlgorithm(token *jwt.JSONWebToken) error {
+	for _, header := range token.Headers {
+		switch jose.SignatureAlgorithm(header.Algorithm) {
+		case jose.RS256, jose.ES256:
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported license JWT signing algorithm")
+}
+
+// licenseCAPEM is the CA certificate used to verify a license JWT's
+// signature. It defaults to info.LicenseCA; tests override it so they can
+// sign tokens against a throwaway CA instead of the real one.
+var licenseCAPEM = []byte(info.LicenseCA)
+
+// licenseCASigningKey parses licenseCAPEM into the public key used to
+// verify a license JWT's signature.
+func licenseCASigningKey() (interface{}, error) {
+	cert, err := parsePEMCertificate(licenseCAPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse license CA, reason: %v", err)
+	}
+	return cert.PublicKey, nil
+}
+
+// parsePEMCertificate decodes a single PEM-encoded x509 certificate.
+func parsePEMCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -0,0 +1,179 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	verifier "go.bytebuilders.dev/license-verifier"
+	"go.bytebuilders.dev/license-verifier/kubernetes/apis/licenses/v1alpha1"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	fakekube "k8s.io/client-go/kubernetes/fake"
+)
+
+// stubLicenseSource is a LicenseSource test double that hands back
+// preset license bytes instead of reading a real file or Secret.
+type stubLicenseSource struct {
+	data []byte
+	name string
+}
+
+func (s stubLicenseSource) Load(context.Context, kubernetes.Interface) ([]byte, string, error) {
+	return s.data, s.name, nil
+}
+
+func (s stubLicenseSource) Watch(context.Context, kubernetes.Interface, func()) error {
+	return nil
+}
+
+// signedTestLicenseJWT generates a throwaway RS256 CA, points licenseCAPEM
+// at it for the duration of the test, and returns a compact JWT signed by
+// that CA's private key carrying the given claims.
+func signedTestLicenseJWT(t *testing.T, claims licenseClaims) []byte {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate signing key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{Organization: []string{"test"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create test CA cert: %v", err)
+	}
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+
+	previous := licenseCAPEM
+	licenseCAPEM = caPEM
+	t.Cleanup(func() { licenseCAPEM = previous })
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, nil)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatalf("failed to sign test license JWT: %v", err)
+	}
+	return []byte(token)
+}
+
+func TestParseJWTLicense(t *testing.T) {
+	now := time.Now()
+	claims := licenseClaims{
+		Claims: jwt.Claims{
+			Issuer:    "byte.builders",
+			Subject:   "test-cluster-uid",
+			ID:        "license-id-1",
+			NotBefore: jwt.NewNumericDate(now.Add(-time.Hour)),
+			Expiry:    jwt.NewNumericDate(now.Add(24 * time.Hour)),
+		},
+		Products: []string{"kubedb"},
+		Clusters: []string{"test-cluster"},
+	}
+	token := signedTestLicenseJWT(t, claims)
+
+	le := &LicenseEnforcer{opts: &verifier.Options{ClusterUID: "test-cluster-uid"}}
+	license, err := le.parseJWTLicense(token)
+	if err != nil {
+		t.Fatalf("parseJWTLicense failed on a validly signed token: %v", err)
+	}
+	if license.LicenseFormat != v1alpha1.LicenseFormatJWT {
+		t.Errorf("expected LicenseFormatJWT, got %v", license.LicenseFormat)
+	}
+	if license.ID != "license-id-1" {
+		t.Errorf("expected ID license-id-1, got %v", license.ID)
+	}
+	if len(license.Products) != 1 || license.Products[0] != "kubedb" {
+		t.Errorf("expected products [kubedb], got %v", license.Products)
+	}
+}
+
+func TestParseJWTLicenseRejectsWrongCluster(t *testing.T) {
+	claims := licenseClaims{
+		Claims: jwt.Claims{Subject: "some-other-cluster"},
+	}
+	token := signedTestLicenseJWT(t, claims)
+
+	le := &LicenseEnforcer{opts: &verifier.Options{ClusterUID: "test-cluster-uid"}}
+	if _, err := le.parseJWTLicense(token); err == nil {
+		t.Fatal("expected an error for a JWT issued to a different cluster, got nil")
+	}
+}
+
+// TestLoadLicenseJWTSkipsPEMVerifier proves LoadLicense's JWT path never
+// routes a verified JWT through verifier.VerifyLicense (which only
+// understands PEM x509 and would otherwise report every JWT license
+// LicenseExpired): it drives LoadLicense itself, with a stub LicenseSource
+// standing in for the license file/Secret and a fake clientset standing in
+// for readClusterUID's kube-system namespace lookup, rather than hand-
+// simulating LoadLicense's internal dispatch.
+func TestLoadLicenseJWTSkipsPEMVerifier(t *testing.T) {
+	now := time.Now()
+	claims := licenseClaims{
+		Claims: jwt.Claims{
+			Subject:   "test-cluster-uid",
+			ID:        "license-id-2",
+			NotBefore: jwt.NewNumericDate(now.Add(-time.Hour)),
+			Expiry:    jwt.NewNumericDate(now.Add(24 * time.Hour)),
+		},
+	}
+	token := signedTestLicenseJWT(t, claims)
+
+	kubeSystem := &core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: core.NamespaceSystem, UID: "test-cluster-uid"},
+	}
+
+	le := &LicenseEnforcer{
+		opts:      &verifier.Options{License: token},
+		source:    stubLicenseSource{data: token, name: "license.jwt"},
+		k8sClient: fakekube.NewSimpleClientset(kubeSystem),
+		dynClient: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()),
+	}
+
+	license := le.LoadLicense()
+	if license.Status != v1alpha1.LicenseActive {
+		t.Fatalf("expected LoadLicense to report the JWT license Active (not run through the PEM verifier), got status %v reason %v", license.Status, license.Reason)
+	}
+	if license.LicenseFormat != v1alpha1.LicenseFormatJWT {
+		t.Errorf("expected LicenseFormatJWT, got %v", license.LicenseFormat)
+	}
+	if license.ID != "license-id-2" {
+		t.Errorf("expected ID license-id-2, got %v", license.ID)
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -41,6 +42,7 @@ import (
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apiserver/pkg/server/mux"
+	clientdynamic "k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	clientscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
@@ -56,23 +58,55 @@ import (
 const (
 	EventSourceLicenseVerifier           = "License Verifier"
 	EventReasonLicenseVerificationFailed = "License Verification Failed"
+	EventReasonLicenseNearExpiry         = "License Near Expiry"
 
 	licensePath          = "/appscode/license"
 	licenseCheckInterval = 1 * time.Hour
 )
 
 type LicenseEnforcer struct {
-	opts        *verifier.Options
-	config      *rest.Config
-	k8sClient   kubernetes.Interface
-	licenseFile string
+	opts          *verifier.Options
+	config        *rest.Config
+	k8sClient     kubernetes.Interface
+	licenseFile   string
+	licenseFormat v1alpha1.LicenseFormat
+	source        LicenseSource
+
+	// GracePeriod is how long handleLicenseVerificationFailure tolerates
+	// verification failures after the last successful verification before
+	// honoring FailurePolicy. Zero means no tolerance: FailurePolicy takes
+	// effect on the first failure, same as before GracePeriod existed.
+	GracePeriod time.Duration
+	// FailurePolicy controls what happens once GracePeriod has elapsed.
+	// The zero value, FailurePolicyTerminate, preserves the original
+	// unconditional-SIGINT behavior.
+	FailurePolicy FailurePolicy
+	// NearExpiryThreshold is how far out from License.NotAfter
+	// reconcileLicenseStatus flips the NearExpiry condition. Zero defaults
+	// to DefaultNearExpiryThreshold.
+	NearExpiryThreshold time.Duration
+
+	mu             sync.RWMutex
+	current        v1alpha1.License
+	lastVerifiedAt time.Time
+	nearExpiry     bool
+	dynClient      clientdynamic.Interface
 }
 
-// NewLicenseEnforcer returns a newly created license enforcer
+// NewLicenseEnforcer returns a newly created license enforcer that reads
+// its license from a file. Use NewLicenseEnforcerWithSource to load from a
+// Secret (or any other LicenseSource) instead.
 func NewLicenseEnforcer(config *rest.Config, licenseFile string) *LicenseEnforcer {
+	return NewLicenseEnforcerWithSource(config, FileLicenseSource(licenseFile))
+}
+
+// NewLicenseEnforcerWithSource returns a license enforcer that reads its
+// license from the given LicenseSource, e.g. SecretLicenseSource so
+// operators don't have to project a Secret onto disk via a volume mount.
+func NewLicenseEnforcerWithSource(config *rest.Config, source LicenseSource) *LicenseEnforcer {
 	return &LicenseEnforcer{
-		licenseFile: licenseFile,
-		config:      config,
+		config: config,
+		source: source,
 		opts: &verifier.Options{
 			CACert:      []byte(info.LicenseCA),
 			ProductName: info.ProductName,
@@ -83,13 +117,45 @@ func NewLicenseEnforcer(config *rest.Config, licenseFile string) *LicenseEnforce
 func (le *LicenseEnforcer) createClients() (err error) {
 	if le.k8sClient == nil {
 		le.k8sClient, err = kubernetes.NewForConfig(le.config)
+		if err != nil {
+			return err
+		}
+	}
+	if le.dynClient == nil {
+		le.dynClient, err = clientdynamic.NewForConfig(le.config)
 	}
 	return err
 }
 
-func (le *LicenseEnforcer) readLicenseFromFile() (err error) {
-	le.opts.License, err = ioutil.ReadFile(le.licenseFile)
-	return err
+// readLicenseFromFile loads the license through le.source (despite the
+// name, kept for the call sites below; the only built-in source left that
+// reads an actual file is FileLicenseSource) and records which encoding it
+// sniffed out so LoadLicense knows how to parse it.
+func (le *LicenseEnforcer) readLicenseFromFile() error {
+	data, name, err := le.source.Load(context.TODO(), le.k8sClient)
+	if err != nil {
+		return err
+	}
+	le.opts.License = data
+	le.licenseFormat = detectLicenseFormat(name, data)
+	return nil
+}
+
+// detectLicenseFormat sniffs whether a license is a PEM x509 certificate or
+// a compact JWS/JWT token, preferring the file extension when present and
+// falling back to the byte content (a PEM block always starts with
+// "-----BEGIN", a JWT never does).
+func detectLicenseFormat(path string, data []byte) v1alpha1.LicenseFormat {
+	switch {
+	case strings.HasSuffix(path, ".jwt"):
+		return v1alpha1.LicenseFormatJWT
+	case strings.HasSuffix(path, ".pem"):
+		return v1alpha1.LicenseFormatPEM
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		return v1alpha1.LicenseFormatPEM
+	}
+	return v1alpha1.LicenseFormatJWT
 }
 
 func (le *LicenseEnforcer) readClusterUID() (err error) {
@@ -109,20 +175,90 @@ func (le *LicenseEnforcer) podName() (string, error) {
 	return "", errors.New("failed to detect pod name")
 }
 
-func (le *LicenseEnforcer) handleLicenseVerificationFailure(licenseErr error) error {
-	// Send interrupt so that all go-routines shut-down gracefully
-	//nolint:errcheck
-	defer syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+// recordVerificationSuccess timestamps a successful verification and clears
+// any degraded state left over from a prior GracePeriod expiry, so a
+// recovered remote licensing service (or a renewed license) un-degrades the
+// process instead of staying degraded forever.
+func (le *LicenseEnforcer) recordVerificationSuccess() {
+	le.mu.Lock()
+	le.lastVerifiedAt = time.Now()
+	le.mu.Unlock()
+	setDegraded(false)
+}
 
+// withinGracePeriod reports whether the last successful verification
+// happened within GracePeriod. It's false before the first successful
+// verification, so a license that has never once verified gets no grace.
+func (le *LicenseEnforcer) withinGracePeriod() bool {
+	le.mu.RLock()
+	last := le.lastVerifiedAt
+	le.mu.RUnlock()
+	return !last.IsZero() && time.Since(last) < le.GracePeriod
+}
+
+// markLicenseExpired records licenseErr as the reason the currently served
+// license is expired, so CurrentLicense (and CheckLicenseEndpoint callers
+// reading it through the /appscode/license handler) sees the failure even
+// while GracePeriod is still suppressing FailurePolicy.
+func (le *LicenseEnforcer) markLicenseExpired(licenseErr error) {
+	le.mu.Lock()
+	le.current.Status = v1alpha1.LicenseExpired
+	le.current.Reason = licenseErr.Error()
+	le.mu.Unlock()
+}
+
+// handleLicenseVerificationFailure records licenseErr against the served
+// license and always emits the Warning Event it always has. Within
+// GracePeriod of the last successful verification it stops there, treating
+// the failure as a blip. Once GracePeriod has elapsed it honors
+// FailurePolicy: FailurePolicyTerminate (the default) SIGINTs the process
+// like this package always has, FailurePolicyDegradedReadOnly flips
+// IsDegraded() instead, and FailurePolicyEmitEventOnly does nothing further.
+func (le *LicenseEnforcer) handleLicenseVerificationFailure(licenseErr error) error {
 	// Log licenseInfo verification failure
 	klog.Errorln("Failed to verify license. Reason: ", licenseErr.Error())
 
+	le.markLicenseExpired(licenseErr)
+	eventErr := le.emitLicenseVerificationFailureEvent(licenseErr)
+
+	if le.withinGracePeriod() {
+		klog.Warningf("license verification failed within grace period %s, not acting on it: %v", le.GracePeriod, licenseErr)
+		return eventErr
+	}
+
+	switch le.FailurePolicy {
+	case FailurePolicyDegradedReadOnly:
+		klog.Warningln("license verification failure grace period elapsed, entering degraded read-only mode")
+		setDegraded(true)
+	case FailurePolicyEmitEventOnly:
+		// Already emitted above; nothing more to do.
+	default:
+		// Send interrupt so that all go-routines shut-down gracefully
+		//nolint:errcheck
+		defer syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+	}
+	return eventErr
+}
+
+// emitLicenseVerificationFailureEvent records the Warning Event against the
+// root owner of the current pod, same as handleLicenseVerificationFailure
+// has always done regardless of GracePeriod or FailurePolicy.
+func (le *LicenseEnforcer) emitLicenseVerificationFailureEvent(licenseErr error) error {
+	return le.emitEvent(core.EventTypeWarning, EventReasonLicenseVerificationFailed,
+		fmt.Sprintf("Failed to verify license. Reason: %s", licenseErr.Error()))
+}
+
+// ownerRef resolves the root owner of the current pod, the object
+// emitEvent records every license Event against, so a licensing problem
+// shows up in `kubectl describe` of the workload an operator admin actually
+// manages rather than a bare pod.
+func (le *LicenseEnforcer) ownerRef() (ref *core.ObjectReference, namespace string, err error) {
 	podName, err := le.podName()
 	if err != nil {
-		return err
+		return nil, "", err
 	}
 	// Read the namespace of current pod
-	namespace := meta.Namespace()
+	namespace = meta.Namespace()
 
 	// Find the root owner of this pod
 	owner, _, err := dynamic.DetectWorkload(
@@ -133,23 +269,34 @@ func (le *LicenseEnforcer) handleLicenseVerificationFailure(licenseErr error) er
 		podName,
 	)
 	if err != nil {
-		return err
+		return nil, "", err
+	}
+	ref, err = reference.GetReference(clientscheme.Scheme, owner)
+	if err != nil {
+		return nil, "", err
 	}
-	ref, err := reference.GetReference(clientscheme.Scheme, owner)
+	return ref, namespace, nil
+}
+
+// emitEvent records an Event of the given type/reason/message against the
+// root owner of the current pod, same as handleLicenseVerificationFailure
+// has always done for verification failures; reconcileLicenseStatus reuses
+// it for the NearExpiry transition Event.
+func (le *LicenseEnforcer) emitEvent(eventType, reason, message string) error {
+	ref, namespace, err := le.ownerRef()
 	if err != nil {
 		return err
 	}
 	eventMeta := metav1.ObjectMeta{
-		Name:      meta.NameWithSuffix(owner.GetName(), "license"),
+		Name:      meta.NameWithSuffix(ref.Name, "license"),
 		Namespace: namespace,
 	}
-	// Create an event against the root owner specifying that the license verification failed
 	_, _, err = core_util.CreateOrPatchEvent(context.TODO(), le.k8sClient, eventMeta, func(in *core.Event) *core.Event {
 		in.InvolvedObject = *ref
-		in.Type = core.EventTypeWarning
+		in.Type = eventType
 		in.Source = core.EventSource{Component: EventSourceLicenseVerifier}
-		in.Reason = EventReasonLicenseVerificationFailed
-		in.Message = fmt.Sprintf("Failed to verify license. Reason: %s", licenseErr.Error())
+		in.Reason = reason
+		in.Message = message
 
 		if in.FirstTimestamp.IsZero() {
 			in.FirstTimestamp = metav1.Now()
@@ -174,18 +321,62 @@ func (le *LicenseEnforcer) Install(c *mux.PathRecorderMux) {
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("x-content-type-options", "nosniff")
 
-		utilruntime.Must(json.NewEncoder(w).Encode(le.LoadLicense()))
+		utilruntime.Must(json.NewEncoder(w).Encode(le.CurrentLicense()))
 	}))
+
+	// Prime the cache immediately so the handler above has something to
+	// serve before the first periodic verification tick fires, and so a
+	// Secret-backed source's Watch can push fresh verifications in between
+	// ticks.
+	le.setCurrentLicense(le.LoadLicense())
+	go func() {
+		ticker := time.NewTicker(licenseCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			le.setCurrentLicense(le.LoadLicense())
+		}
+	}()
+	if err := le.source.Watch(context.TODO(), le.k8sClient, func() {
+		le.setCurrentLicense(le.LoadLicense())
+	}); err != nil {
+		klog.Warningf("license source does not support watching for changes: %v", err)
+	}
+}
+
+// CurrentLicense returns the most recently verified license without
+// re-reading the LicenseSource; LoadLicense is what keeps it fresh, called
+// every licenseCheckInterval by the loop Install starts or on-demand by
+// Install's Watch callback above.
+func (le *LicenseEnforcer) CurrentLicense() v1alpha1.License {
+	le.mu.RLock()
+	defer le.mu.RUnlock()
+	return le.current
+}
+
+func (le *LicenseEnforcer) setCurrentLicense(license v1alpha1.License) {
+	le.mu.Lock()
+	le.current = license
+	le.mu.Unlock()
 }
 
 func (le *LicenseEnforcer) LoadLicense() v1alpha1.License {
 	utilruntime.Must(le.createClients())
 
 	var license v1alpha1.License
-	license.TypeMeta = metav1.TypeMeta{
+	typeMeta := metav1.TypeMeta{
 		APIVersion: v1alpha1.SchemeGroupVersion.String(),
 		Kind:       meta.GetKind(license),
 	}
+	license.TypeMeta = typeMeta
+
+	// Reconcile the cluster-scoped LicenseStatus CRD with whatever this
+	// call returns, success or failure, so `kubectl get licensestatus`
+	// stays in lockstep with every verification cycle Install drives.
+	defer func() {
+		if err := le.reconcileLicenseStatus(license); err != nil {
+			klog.Warningf("failed to reconcile LicenseStatus: %v", err)
+		}
+	}()
 
 	// Read cluster UID (UID of the "kube-system" namespace)
 	err := le.readClusterUID()
@@ -201,50 +392,84 @@ func (le *LicenseEnforcer) LoadLicense() v1alpha1.License {
 		license.Reason = err.Error()
 		return license
 	}
-	// Parse license
-
-	block, _ := pem.Decode(le.opts.License)
-	if block == nil {
-		// This probably is a JWT token, should be check for that when ready
-		license.Status = v1alpha1.LicenseUnknown
-		license.Reason = "failed to parse certificate PEM"
-		return license
+	// Parse license, PEM x509 cert or JWT, whichever readLicenseFromFile sniffed out.
+	if le.licenseFormat == v1alpha1.LicenseFormatJWT {
+		license, err = le.parseJWTLicense(le.opts.License)
+	} else {
+		license, err = le.parsePEMLicense(le.opts.License)
 	}
-	cert, err := x509.ParseCertificate(block.Bytes)
+	license.TypeMeta = typeMeta
 	if err != nil {
 		license.Status = v1alpha1.LicenseUnknown
-		license.Reason = "failed to parse certificate, reason:" + err.Error()
+		license.Reason = err.Error()
 		return license
 	}
 
-	license = v1alpha1.License{
-		Issuer:    "byte.builders",
-		Clusters:  cert.DNSNames,
-		NotBefore: &metav1.Time{Time: cert.NotBefore},
-		NotAfter:  &metav1.Time{Time: cert.NotAfter},
-		ID:        cert.SerialNumber.String(),
-		Products:  cert.Subject.Organization,
+	// verifier.VerifyLicense only understands the PEM x509 encoding; a JWT
+	// license has already had its signature and cluster verified above by
+	// parseJWTLicense, so only its nbf/exp window is left to check.
+	if le.licenseFormat != v1alpha1.LicenseFormatJWT {
+		// ref: https://github.com/appscode/gitea/blob/master/models/stripe_license.go#L117-L126
+		err = verifier.VerifyLicense(le.opts)
+	} else {
+		err = checkJWTLicenseWindow(license)
 	}
-	// ref: https://github.com/appscode/gitea/blob/master/models/stripe_license.go#L117-L126
-	if err = verifier.VerifyLicense(le.opts); err != nil {
+	if err != nil {
 		license.Status = v1alpha1.LicenseExpired
 		license.Reason = err.Error()
 	} else {
 		license.Status = v1alpha1.LicenseActive
+		le.recordVerificationSuccess()
 	}
 	return license
 }
 
+// parsePEMLicense extracts License metadata out of a PEM-encoded x509
+// certificate, the format license-verifier has always issued.
+func (le *LicenseEnforcer) parsePEMLicense(data []byte) (v1alpha1.License, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return v1alpha1.License{}, errors.New("failed to parse certificate PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return v1alpha1.License{}, fmt.Errorf("failed to parse certificate, reason: %v", err)
+	}
+
+	return v1alpha1.License{
+		Issuer:        "byte.builders",
+		Clusters:      cert.DNSNames,
+		NotBefore:     &metav1.Time{Time: cert.NotBefore},
+		NotAfter:      &metav1.Time{Time: cert.NotAfter},
+		ID:            cert.SerialNumber.String(),
+		Products:      cert.Subject.Organization,
+		LicenseFormat: v1alpha1.LicenseFormatPEM,
+	}, nil
+}
+
 // VerifyLicensePeriodically periodically verifies whether the provided license is valid for the current cluster or not.
 func VerifyLicensePeriodically(config *rest.Config, licenseFile string, stopCh <-chan struct{}) error {
+	return VerifyLicensePeriodicallyWithGracePeriod(config, licenseFile, 0, FailurePolicyTerminate, stopCh)
+}
+
+// VerifyLicensePeriodicallyWithGracePeriod is VerifyLicensePeriodically with
+// control over how a failing license is handled: within gracePeriod of the
+// last successful verification, failures are only logged and Evented; once
+// it elapses, failurePolicy decides whether the process terminates, degrades
+// to read-only, or keeps running untouched. gracePeriod <= 0 preserves
+// VerifyLicensePeriodically's original no-tolerance behavior.
+func VerifyLicensePeriodicallyWithGracePeriod(config *rest.Config, licenseFile string, gracePeriod time.Duration, failurePolicy FailurePolicy, stopCh <-chan struct{}) error {
 	if info.SkipLicenseVerification() {
 		klog.Infoln("License verification skipped")
 		return nil
 	}
 
 	le := &LicenseEnforcer{
-		licenseFile: licenseFile,
-		config:      config,
+		licenseFile:   licenseFile,
+		source:        FileLicenseSource(licenseFile),
+		config:        config,
+		GracePeriod:   gracePeriod,
+		FailurePolicy: failurePolicy,
 		opts: &verifier.Options{
 			CACert:      []byte(info.LicenseCA),
 			ProductName: info.ProductName,
@@ -275,6 +500,7 @@ func VerifyLicensePeriodically(config *rest.Config, licenseFile string, stopCh <
 			return false, le.handleLicenseVerificationFailure(err)
 		}
 		klog.Infoln("Successfully verified license!")
+		le.recordVerificationSuccess()
 		// return false so that the loop never ends
 		return false, nil
 	}
@@ -304,6 +530,7 @@ func CheckLicenseFile(config *rest.Config, licenseFile string) error {
 	klog.V(8).Infoln("Verifying license.......")
 	le := &LicenseEnforcer{
 		licenseFile: licenseFile,
+		source:      FileLicenseSource(licenseFile),
 		config:      config,
 		opts: &verifier.Options{
 			CACert:      []byte(info.LicenseCA),
@@ -331,6 +558,7 @@ func CheckLicenseFile(config *rest.Config, licenseFile string) error {
 		return le.handleLicenseVerificationFailure(err)
 	}
 	klog.Infoln("Successfully verified license!")
+	le.recordVerificationSuccess()
 	return nil
 }
 
@@ -0,0 +1,178 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.bytebuilders.dev/license-verifier/info"
+	"go.bytebuilders.dev/license-verifier/kubernetes/apis/licenses/v1alpha1"
+
+	kmapi "kmodules.xyz/client-go/apis/kmapi"
+
+	core "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog"
+)
+
+// DefaultNearExpiryThreshold is how far out from License.NotAfter
+// reconcileLicenseStatus flips the NearExpiry condition when the caller
+// hasn't set LicenseEnforcer.NearExpiryThreshold.
+const DefaultNearExpiryThreshold = 14 * 24 * time.Hour
+
+// licenseStatusFieldManager is the field manager reconcileLicenseStatus
+// server-side-applies under, so every replica of the same operator
+// reconciling the same cluster-scoped LicenseStatus converges on one
+// result instead of fighting over ownership of its fields.
+const licenseStatusFieldManager = "license-verifier"
+
+var licenseStatusGVR = schema.GroupVersionResource{
+	Group:    v1alpha1.SchemeGroupVersion.Group,
+	Version:  v1alpha1.SchemeGroupVersion.Version,
+	Resource: v1alpha1.ResourceLicenseStatuses,
+}
+
+// reconcileLicenseStatus server-side-applies license onto the cluster-scoped
+// LicenseStatus object named after info.ProductName, so `kubectl get
+// licensestatus` and kube-state-metrics reflect the same state every
+// replica of this product's operator serves over /appscode/license. It
+// also flips the NearExpiry condition against NearExpiryThreshold and fires
+// a Normal Event the one time that condition transitions.
+func (le *LicenseEnforcer) reconcileLicenseStatus(license v1alpha1.License) error {
+	if le.dynClient == nil {
+		if err := le.createClients(); err != nil {
+			return err
+		}
+	}
+
+	nearExpiry := le.isNearExpiry(license)
+	le.recordNearExpiryTransition(nearExpiry)
+
+	var existingConditions []kmapi.Condition
+	existing, err := le.dynClient.Resource(licenseStatusGVR).Get(context.TODO(), info.ProductName, metav1.GetOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return fmt.Errorf("failed to read existing LicenseStatus %q: %w", info.ProductName, err)
+	}
+	if existing != nil {
+		var prev v1alpha1.LicenseStatus
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(existing.UnstructuredContent(), &prev); err == nil {
+			existingConditions = prev.Status.Conditions
+		}
+	}
+
+	status := &v1alpha1.LicenseStatus{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1alpha1.SchemeGroupVersion.String(),
+			Kind:       v1alpha1.ResourceKindLicenseStatus,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: info.ProductName,
+		},
+		Status: v1alpha1.LicenseStatusStatus{
+			License:    license,
+			Conditions: buildLicenseConditions(existingConditions, license, nearExpiry),
+		},
+	}
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(status)
+	if err != nil {
+		return fmt.Errorf("failed to convert LicenseStatus %q to unstructured: %w", status.Name, err)
+	}
+	obj := &unstructured.Unstructured{Object: content}
+	applyOpts := metav1.ApplyOptions{
+		FieldManager: licenseStatusFieldManager,
+		Force:        true,
+	}
+
+	// The object (metadata/typemeta) and its status live behind separate
+	// field managers once a status subresource is registered, so creating it
+	// and pushing its status take two Apply calls: the first ensures the
+	// LicenseStatus exists at all, the second actually persists .status.
+	meta := obj.DeepCopy()
+	unstructured.RemoveNestedField(meta.Object, "status")
+	if _, err := le.dynClient.Resource(licenseStatusGVR).Apply(context.TODO(), status.Name, meta, applyOpts); err != nil {
+		return fmt.Errorf("failed to apply LicenseStatus %q: %w", status.Name, err)
+	}
+
+	_, err = le.dynClient.Resource(licenseStatusGVR).ApplyStatus(context.TODO(), status.Name, obj, applyOpts)
+	return err
+}
+
+// isNearExpiry reports whether license's NotAfter falls within
+// NearExpiryThreshold (DefaultNearExpiryThreshold if unset) of now. An
+// already-expired or not-yet-active license isn't "near" expiry, it's
+// simply not Active, so this only applies to a currently active license.
+func (le *LicenseEnforcer) isNearExpiry(license v1alpha1.License) bool {
+	if license.Status != v1alpha1.LicenseActive || license.NotAfter == nil {
+		return false
+	}
+	threshold := le.NearExpiryThreshold
+	if threshold <= 0 {
+		threshold = DefaultNearExpiryThreshold
+	}
+	return time.Until(license.NotAfter.Time) < threshold
+}
+
+// recordNearExpiryTransition emits a Normal Event the one time nearExpiry
+// flips relative to the last reconcile, rather than on every reconcile
+// cycle the NearExpiry condition stays true.
+func (le *LicenseEnforcer) recordNearExpiryTransition(nearExpiry bool) {
+	le.mu.Lock()
+	transitioned := nearExpiry != le.nearExpiry
+	le.nearExpiry = nearExpiry
+	le.mu.Unlock()
+
+	if !transitioned || !nearExpiry {
+		return
+	}
+	if err := le.emitEvent(core.EventTypeNormal, EventReasonLicenseNearExpiry, "License is nearing expiry"); err != nil {
+		klog.Warningf("failed to emit %s event: %v", EventReasonLicenseNearExpiry, err)
+	}
+}
+
+// buildLicenseConditions derives the Verified/NearExpiry/Expired/Unreachable
+// LicenseStatus conditions from license, the same metadata the
+// /appscode/license handler already serves. Each condition is merged onto
+// existing via kmapi.SetCondition rather than rebuilt from scratch, so a
+// condition whose Status hasn't flipped keeps its original
+// LastTransitionTime instead of churning it on every reconcile.
+func buildLicenseConditions(existing []kmapi.Condition, license v1alpha1.License, nearExpiry bool) []kmapi.Condition {
+	condition := func(typ v1alpha1.LicenseCondition, status bool, reason string) kmapi.Condition {
+		c := kmapi.Condition{
+			Type:   string(typ),
+			Status: core.ConditionFalse,
+			Reason: reason,
+		}
+		if status {
+			c.Status = core.ConditionTrue
+		}
+		return c
+	}
+
+	conditions := existing
+	conditions = kmapi.SetCondition(conditions, condition(v1alpha1.LicenseConditionVerified, license.Status == v1alpha1.LicenseActive, string(license.Status)))
+	conditions = kmapi.SetCondition(conditions, condition(v1alpha1.LicenseConditionNearExpiry, nearExpiry, string(license.Status)))
+	conditions = kmapi.SetCondition(conditions, condition(v1alpha1.LicenseConditionExpired, license.Status == v1alpha1.LicenseExpired, string(license.Status)))
+	conditions = kmapi.SetCondition(conditions, condition(v1alpha1.LicenseConditionUnreachable, license.Status == v1alpha1.LicenseUnknown, license.Reason))
+	return conditions
+}
@@ -0,0 +1,72 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"go.bytebuilders.dev/license-verifier/kubernetes/apis/licenses/v1alpha1"
+
+	kmapi "kmodules.xyz/client-go/apis/kmapi"
+)
+
+func findCondition(conditions []kmapi.Condition, typ v1alpha1.LicenseCondition) *kmapi.Condition {
+	for i := range conditions {
+		if conditions[i].Type == string(typ) {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+func TestBuildLicenseConditionsPreservesLastTransitionTimeWhenUnchanged(t *testing.T) {
+	active := v1alpha1.License{Status: v1alpha1.LicenseActive}
+
+	first := buildLicenseConditions(nil, active, false)
+	verified := findCondition(first, v1alpha1.LicenseConditionVerified)
+	if verified == nil {
+		t.Fatal("expected a Verified condition")
+	}
+	firstTransition := verified.LastTransitionTime
+
+	time.Sleep(10 * time.Millisecond)
+	second := buildLicenseConditions(first, active, false)
+	verifiedAgain := findCondition(second, v1alpha1.LicenseConditionVerified)
+	if verifiedAgain == nil {
+		t.Fatal("expected a Verified condition")
+	}
+	if !verifiedAgain.LastTransitionTime.Equal(&firstTransition) {
+		t.Fatalf("expected LastTransitionTime to be preserved when status is unchanged, got %v want %v", verifiedAgain.LastTransitionTime, firstTransition)
+	}
+}
+
+func TestBuildLicenseConditionsUpdatesLastTransitionTimeOnFlip(t *testing.T) {
+	active := v1alpha1.License{Status: v1alpha1.LicenseActive}
+	expired := v1alpha1.License{Status: v1alpha1.LicenseExpired}
+
+	first := buildLicenseConditions(nil, active, false)
+	firstTransition := findCondition(first, v1alpha1.LicenseConditionVerified).LastTransitionTime
+
+	time.Sleep(10 * time.Millisecond)
+	second := buildLicenseConditions(first, expired, false)
+	secondTransition := findCondition(second, v1alpha1.LicenseConditionVerified).LastTransitionTime
+
+	if secondTransition.Equal(&firstTransition) {
+		t.Fatal("expected LastTransitionTime to update once the Verified condition's Status actually flips")
+	}
+}
@@ -0,0 +1,231 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	verifier "go.bytebuilders.dev/license-verifier"
+	"go.bytebuilders.dev/license-verifier/info"
+	"go.bytebuilders.dev/license-verifier/kubernetes/apis/licenses/v1alpha1"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+)
+
+// DefaultInitialPollingPeriod is how often VerifyLicensePeriodicallyWithGetter
+// polls a LicenseGetter before the first successful fetch+verify, so a
+// freshly started operator doesn't sit idle for a whole PollingPeriod
+// waiting to notice its license became reachable.
+const DefaultInitialPollingPeriod = 60 * time.Second
+
+// maxRemoteBackoffFactor is how much the exponential backoff
+// VerifyLicensePeriodicallyWithGetter applies to transient fetch failures
+// grows after each retry; the backoff itself is capped at the steady
+// PollingPeriod.
+const maxRemoteBackoffFactor = 2
+
+// LicenseGetter fetches raw license bytes from a remote source. It exists
+// alongside LicenseSource because a remote fetch has different failure
+// semantics: a transient outage of the remote service should back off and
+// keep serving the last cached license, not trip
+// handleLicenseVerificationFailure the way a missing local file would.
+type LicenseGetter interface {
+	Get(ctx context.Context) ([]byte, error)
+}
+
+// RemoteLicenseGetter fetches a license from a configurable HTTPS endpoint,
+// e.g. a ByteBuilders license-proxyserver URL, authenticated with either a
+// bearer token or mTLS (configure client certs on HTTPClient.Transport).
+type RemoteLicenseGetter struct {
+	URL         string
+	BearerToken string
+	HTTPClient  *http.Client
+}
+
+func (g *RemoteLicenseGetter) Get(ctx context.Context) ([]byte, error) {
+	client := g.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if g.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+g.BearerToken)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("license-proxyserver %s returned status %d", g.URL, resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// VerifyLicensePeriodicallyWithGetter is VerifyLicensePeriodically's
+// counterpart for a remote LicenseGetter. It polls every
+// initialPollingPeriod until the first successful fetch+verify, then falls
+// back to the steady pollingPeriod (0 defaults to the existing 1h). On a
+// transient fetch or verification failure *after* a license has already
+// been verified once, it backs off exponentially (capped at
+// pollingPeriod) instead of calling handleLicenseVerificationFailure, so a
+// brief outage of the remote licensing service does not SIGINT every
+// operator in the cluster; failures inside the polling loop never call
+// handleLicenseVerificationFailure, however long they last. The last
+// successfully verified license stays cached on the LicenseEnforcer this
+// function creates internally; that enforcer is never passed to Install, so
+// its cache is not served over /appscode/license. gracePeriod and
+// failurePolicy are set on that LicenseEnforcer, but
+// handleLicenseVerificationFailure only runs here when creating clients or
+// reading the cluster UID fails, before any license has verified, so no
+// grace period applies and failurePolicy alone decides those failures.
+func VerifyLicensePeriodicallyWithGetter(config *rest.Config, getter LicenseGetter, initialPollingPeriod, pollingPeriod, gracePeriod time.Duration, failurePolicy FailurePolicy, stopCh <-chan struct{}) error {
+	if info.SkipLicenseVerification() {
+		klog.Infoln("License verification skipped")
+		return nil
+	}
+	if pollingPeriod <= 0 {
+		pollingPeriod = licenseCheckInterval
+	}
+	if initialPollingPeriod <= 0 {
+		initialPollingPeriod = DefaultInitialPollingPeriod
+	}
+
+	le := &LicenseEnforcer{
+		config:        config,
+		GracePeriod:   gracePeriod,
+		FailurePolicy: failurePolicy,
+		opts: &verifier.Options{
+			CACert:      []byte(info.LicenseCA),
+			ProductName: info.ProductName,
+		},
+	}
+	if err := le.createClients(); err != nil {
+		return le.handleLicenseVerificationFailure(err)
+	}
+	if err := le.readClusterUID(); err != nil {
+		return le.handleLicenseVerificationFailure(err)
+	}
+
+	period := initialPollingPeriod
+	backoff := time.Second
+	verifiedOnce := false
+
+	for {
+		license, err := le.fetchAndVerify(getter)
+		switch {
+		case err == nil:
+			le.setCurrentLicense(license)
+			le.recordVerificationSuccess()
+			klog.Infoln("Successfully verified license!")
+			backoff = time.Second
+			if !verifiedOnce {
+				verifiedOnce = true
+				period = pollingPeriod
+			}
+		case !verifiedOnce:
+			// Not yet verified even once: keep polling at
+			// initialPollingPeriod rather than SIGINT-ing an operator that
+			// simply hasn't reached the remote licensing service yet.
+			klog.Warningf("license not yet verified, retrying in %s: %v", initialPollingPeriod, err)
+			select {
+			case <-time.After(initialPollingPeriod):
+			case <-stopCh:
+				return nil
+			}
+			continue
+		default:
+			klog.Warningf("transient license verification failure, retrying in %s: %v", backoff, err)
+			select {
+			case <-time.After(backoff):
+			case <-stopCh:
+				return nil
+			}
+			if backoff *= maxRemoteBackoffFactor; backoff > pollingPeriod {
+				backoff = pollingPeriod
+			}
+			continue
+		}
+
+		select {
+		case <-time.After(period):
+		case <-stopCh:
+			return nil
+		}
+	}
+}
+
+// fetchAndVerify fetches license bytes from getter, verifies them, and
+// returns the resulting v1alpha1.License (ready to cache) on success. It
+// reconciles the LicenseStatus CRD with whatever it returns, success or
+// failure, so a remote outage shows up there the same way it does in
+// VerifyLicensePeriodicallyWithGetter's own backoff logging.
+func (le *LicenseEnforcer) fetchAndVerify(getter LicenseGetter) (license v1alpha1.License, err error) {
+	defer func() {
+		if rerr := le.reconcileLicenseStatus(license); rerr != nil {
+			klog.Warningf("failed to reconcile LicenseStatus: %v", rerr)
+		}
+	}()
+
+	data, err := getter.Get(context.TODO())
+	if err != nil {
+		err = fmt.Errorf("failed to fetch license: %w", err)
+		license.Status = v1alpha1.LicenseUnknown
+		license.Reason = err.Error()
+		return license, err
+	}
+
+	le.opts.License = data
+	le.licenseFormat = detectLicenseFormat("", data)
+
+	if le.licenseFormat == v1alpha1.LicenseFormatJWT {
+		license, err = le.parseJWTLicense(data)
+	} else {
+		license, err = le.parsePEMLicense(data)
+	}
+	if err != nil {
+		license.Status = v1alpha1.LicenseUnknown
+		license.Reason = err.Error()
+		return license, err
+	}
+
+	// verifier.VerifyLicense only understands the PEM x509 encoding, same as
+	// in LoadLicense.
+	if le.licenseFormat == v1alpha1.LicenseFormatJWT {
+		err = checkJWTLicenseWindow(license)
+	} else {
+		err = verifier.VerifyLicense(le.opts)
+	}
+	if err != nil {
+		license.Status = v1alpha1.LicenseExpired
+		license.Reason = err.Error()
+		return license, err
+	}
+	license.Status = v1alpha1.LicenseActive
+	return license, nil
+}
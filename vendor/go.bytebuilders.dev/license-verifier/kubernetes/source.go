@@ -0,0 +1,162 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	"go.bytebuilders.dev/license-verifier/info"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// defaultLicenseSecretKey is the Secret data key SecretLicenseSource reads
+// from when the caller doesn't name one explicitly.
+const defaultLicenseSecretKey = "license.txt"
+
+// LicenseSource abstracts over where the raw license bytes come from, so
+// LicenseEnforcer doesn't have to assume a filesystem path the way it
+// always has.
+type LicenseSource interface {
+	// Load reads the current license bytes, plus a name suitable for
+	// sniffing its format via detectLicenseFormat (a file path's
+	// extension, or a Secret's name if nothing better is available).
+	Load(ctx context.Context, client kubernetes.Interface) (data []byte, name string, err error)
+
+	// Watch arranges for onChange to be called whenever the underlying
+	// license changes, so a rotation is picked up immediately instead of
+	// waiting for the next steady-state poll tick. Sources that can't
+	// support push notifications (a plain file) return nil without ever
+	// calling onChange.
+	Watch(ctx context.Context, client kubernetes.Interface, onChange func()) error
+}
+
+// fileLicenseSource reads the license from a path on disk, same as
+// LicenseEnforcer always has.
+type fileLicenseSource struct {
+	path string
+}
+
+// FileLicenseSource returns a LicenseSource that reads the license from a
+// file, e.g. one projected from a Secret via a volume mount.
+func FileLicenseSource(path string) LicenseSource {
+	return &fileLicenseSource{path: path}
+}
+
+func (s *fileLicenseSource) Load(_ context.Context, _ kubernetes.Interface) ([]byte, string, error) {
+	data, err := ioutil.ReadFile(s.path)
+	return data, s.path, err
+}
+
+func (s *fileLicenseSource) Watch(_ context.Context, _ kubernetes.Interface, _ func()) error {
+	return nil
+}
+
+// secretLicenseSource reads the license directly out of a Kubernetes
+// Secret, so operators no longer have to project one onto disk just to
+// satisfy LicenseEnforcer.
+type secretLicenseSource struct {
+	namespace     string
+	labelSelector string
+	key           string
+}
+
+// SecretLicenseSource returns a LicenseSource that lists Secrets in
+// namespace matching labelSelector (default "license=<product>", see
+// DefaultLicenseLabelSelector) and reads the license out of data key key
+// (default "license.txt"). It fails if more than one Secret matches,
+// mirroring the single-match rule used elsewhere in this ecosystem (e.g.
+// clusterid.ClusterUID's kube-system namespace lookup) rather than
+// silently picking one.
+func SecretLicenseSource(namespace, labelSelector, key string) LicenseSource {
+	if key == "" {
+		key = defaultLicenseSecretKey
+	}
+	if labelSelector == "" {
+		labelSelector = DefaultLicenseLabelSelector(info.ProductName)
+	}
+	return &secretLicenseSource{
+		namespace:     namespace,
+		labelSelector: labelSelector,
+		key:           key,
+	}
+}
+
+// DefaultLicenseLabelSelector returns the label selector SecretLicenseSource
+// uses when the caller doesn't specify one.
+func DefaultLicenseLabelSelector(productName string) string {
+	return fmt.Sprintf("license=%s", productName)
+}
+
+func (s *secretLicenseSource) get(ctx context.Context, client kubernetes.Interface) (*core.Secret, error) {
+	list, err := client.CoreV1().Secrets(s.namespace).List(ctx, metav1.ListOptions{LabelSelector: s.labelSelector})
+	if err != nil {
+		return nil, err
+	}
+	switch len(list.Items) {
+	case 0:
+		return nil, fmt.Errorf("no secret found in namespace %q matching selector %q", s.namespace, s.labelSelector)
+	case 1:
+		return &list.Items[0], nil
+	default:
+		return nil, fmt.Errorf("found %d secrets in namespace %q matching selector %q, expected exactly one", len(list.Items), s.namespace, s.labelSelector)
+	}
+}
+
+func (s *secretLicenseSource) Load(ctx context.Context, client kubernetes.Interface) ([]byte, string, error) {
+	secret, err := s.get(ctx, client)
+	if err != nil {
+		return nil, "", err
+	}
+	data, ok := secret.Data[s.key]
+	if !ok {
+		return nil, "", fmt.Errorf("secret %s/%s has no data key %q", secret.Namespace, secret.Name, s.key)
+	}
+	return data, secret.Name, nil
+}
+
+// Watch runs a label-selector-scoped informer over Secrets in s.namespace
+// and calls onChange on every add/update, so rotating the license Secret
+// triggers an immediate re-verification instead of waiting for the next
+// hourly tick.
+func (s *secretLicenseSource) Watch(ctx context.Context, client kubernetes.Interface, onChange func()) error {
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = s.labelSelector
+			return client.CoreV1().Secrets(s.namespace).List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = s.labelSelector
+			return client.CoreV1().Secrets(s.namespace).Watch(ctx, options)
+		},
+	}
+
+	informer := cache.NewSharedIndexInformer(lw, &core.Secret{}, licenseCheckInterval, cache.Indexers{})
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { onChange() },
+		UpdateFunc: func(interface{}, interface{}) { onChange() },
+	})
+	go informer.Run(ctx.Done())
+	return nil
+}
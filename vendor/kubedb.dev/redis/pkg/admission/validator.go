@@ -0,0 +1,135 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"fmt"
+	"strings"
+
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha2"
+	cs "kubedb.dev/apimachinery/client/clientset/versioned"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// minACLPasswordLength mirrors Redis's own ACLPASS recommendation; anything
+// shorter is rejected outright rather than left for `redis-cli` to reject
+// at runtime with a much less actionable error.
+const minACLPasswordLength = 8
+
+// dangerousACLCommands conflict with cluster mode: any of them can desync
+// cluster slot ownership or wipe the keyspace a shard is responsible for,
+// so a RedisUser is not allowed to grant them via `+@dangerous` (or
+// individually) against a RedisModeCluster target.
+var dangerousACLCommands = []string{"CLUSTER RESET", "FLUSHALL", "FLUSHDB", "DEBUG"}
+
+// ValidateRedis checks if the object satisfies all the requirements.
+// It is not a method of Interface, because it is referenced from the
+// controller package too.
+func ValidateRedis(client kubernetes.Interface, extClient cs.Interface, redis *api.Redis, strictValidation bool) error {
+	if redis.Spec.Version == "" {
+		return errors.New(`'spec.version' is missing`)
+	}
+
+	if redis.Spec.Mode == api.RedisModeSentinel {
+		if err := validateSentinelSpec(redis); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateSentinelSpec enforces the invariants a Sentinel-mode Redis must
+// hold: a quorum that can't ever get stuck (a majority of the sentinel
+// fleet must be able to agree), and that spec.sentinel is actually set.
+func validateSentinelSpec(redis *api.Redis) error {
+	sentinel := redis.Spec.Sentinel
+	if sentinel == nil {
+		return errors.New(`'spec.sentinel' is required when 'spec.mode' is "Sentinel"`)
+	}
+
+	replicas := int32(3)
+	if sentinel.Replicas != nil {
+		replicas = *sentinel.Replicas
+	}
+
+	maxQuorum := replicas/2 + 1
+	if sentinel.Quorum > maxQuorum {
+		return fmt.Errorf(`'spec.sentinel.quorum' (%d) must be <= replicas/2 + 1 (%d) for %d sentinel replicas`,
+			sentinel.Quorum, maxQuorum, replicas)
+	}
+	if sentinel.Quorum < 1 {
+		return fmt.Errorf(`'spec.sentinel.quorum' must be >= 1, got %d`, sentinel.Quorum)
+	}
+	return nil
+}
+
+// ValidateRedisUser rejects weak or empty passwords and command grants that
+// would let a user tear down cluster-mode topology state (slot ownership,
+// the keyspace) out from under the operator's own reconciliation.
+func ValidateRedisUser(client kubernetes.Interface, extClient cs.Interface, redisUser *api.RedisUser) error {
+	if redisUser.Spec.Username == "" {
+		return errors.New(`'spec.username' is missing`)
+	}
+	if redisUser.Spec.PasswordSecretRef == nil || redisUser.Spec.PasswordSecretRef.Name == "" {
+		return errors.New(`'spec.passwordSecretRef' is required; empty passwords are not allowed`)
+	}
+
+	secret, err := client.CoreV1().Secrets(redisUser.Namespace).Get(redisUser.Spec.PasswordSecretRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	password := string(secret.Data[core.BasicAuthPasswordKey])
+	if len(password) < minACLPasswordLength {
+		return fmt.Errorf(`password in secret %q is too weak; must be at least %d characters`, redisUser.Spec.PasswordSecretRef.Name, minACLPasswordLength)
+	}
+
+	redis, err := extClient.KubedbV1alpha2().Redises(redisUser.Namespace).Get(redisUser.Spec.TargetRedisRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if redis.Spec.Mode == api.RedisModeCluster {
+		for _, command := range redisUser.Spec.AllowedCommands {
+			for _, dangerous := range dangerousACLCommands {
+				if strings.EqualFold(command, dangerous) {
+					return fmt.Errorf(`granting %q is not allowed against a cluster-mode Redis`, dangerous)
+				}
+			}
+		}
+		for _, command := range redisUser.Spec.AllowedCommands {
+			if strings.EqualFold(command, "@dangerous") {
+				return fmt.Errorf(`'+@dangerous' is not allowed against a cluster-mode Redis; grant individual commands instead`)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateRedisModeTransition rejects any update that changes spec.mode.
+// Standalone, Sentinel and Cluster deployments use incompatible on-disk
+// layouts and topologies, so migrating between them in place is not
+// supported; the user must provision a new Redis and restore into it.
+func ValidateRedisModeTransition(redis, oldRedis *api.Redis) error {
+	if oldRedis.Spec.Mode != redis.Spec.Mode {
+		return fmt.Errorf(`'spec.mode' is immutable, can't change from %q to %q`, oldRedis.Spec.Mode, redis.Spec.Mode)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha2"
+
+	"github.com/appscode/go/log"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kutil "kmodules.xyz/client-go"
+	core_util "kmodules.xyz/client-go/core/v1"
+)
+
+// aclFileMountPath is where the rendered ACL file is mounted and what
+// redis.conf's `aclfile` directive must point at, so ACL users declared via
+// RedisUser survive a full-cluster restart instead of only living in the
+// in-memory ACL table `ACL SETUSER` populates.
+const aclFileMountPath = "/etc/redis/users.acl"
+
+// redisConfigFileName and aclUsersFileName are the ConfigMap data keys
+// ensureRedisConfig writes, mirroring redis.conf and the aclfile it points
+// at on disk.
+const (
+	redisConfigFileName = "redis.conf"
+	aclUsersFileName    = "users.acl"
+)
+
+// renderACLUsersFile renders the `aclfile` contents for every RedisUser
+// that targets this Redis, one `user ...` line per ACL SETUSER rule set
+// built by buildACLRules. ensureRedisConfig calls this when assembling the
+// ConfigMap data so the file ships alongside redis.conf.
+func (c *Controller) renderACLUsersFile(redisUsers []*api.RedisUser) (string, error) {
+	var b strings.Builder
+	for _, redisUser := range redisUsers {
+		secret, err := c.Client.CoreV1().Secrets(redisUser.Namespace).Get(context.TODO(), redisUser.Spec.PasswordSecretRef.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to read password secret %s/%s for RedisUser %s: %w", redisUser.Namespace, redisUser.Spec.PasswordSecretRef.Name, redisUser.Name, err)
+		}
+		rules := buildACLRules(redisUser, secret.Data[core.BasicAuthPasswordKey])
+		fmt.Fprintf(&b, "user %s %s\n", redisUser.Spec.Username, strings.Join(rules, " "))
+	}
+	return b.String(), nil
+}
+
+// redisUsersTargeting returns every RedisUser in redis's namespace whose
+// spec.targetRedisRef points at redis, the same set reconcileRedisUser
+// (redisuser.go) applies ACL SETUSER for.
+func (c *Controller) redisUsersTargeting(redis *api.Redis) ([]*api.RedisUser, error) {
+	all, err := c.rdUserLister.RedisUsers(redis.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var targeting []*api.RedisUser
+	for _, redisUser := range all {
+		if redisUser.Spec.TargetRedisRef.Name == redis.Name {
+			targeting = append(targeting, redisUser)
+		}
+	}
+	return targeting, nil
+}
+
+// renderRedisConf builds redis.conf for a cluster-mode Redis: the
+// cluster-enabled/cluster-config-file/port directives every cluster-mode
+// node needs to form and persist its cluster topology, plus the aclfile
+// directive pointing at the ACL users file ensureRedisConfig renders
+// alongside it.
+func renderRedisConf() string {
+	return fmt.Sprintf(`cluster-enabled yes
+cluster-config-file nodes.conf
+port 6379
+aclfile %s
+`, aclFileMountPath)
+}
+
+// ensureRedisConfig renders redis.conf (the base cluster-mode directives
+// plus the `aclfile` directive pointing at aclFileMountPath) and the ACL
+// users file built from every RedisUser targeting this Redis into a shared
+// ConfigMap, so ACL users declared via RedisUser survive a full-cluster
+// restart instead of only living in the in-memory ACL table `ACL SETUSER`
+// populates.
+func (c *Controller) ensureRedisConfig(redis *api.Redis) error {
+	redisUsers, err := c.redisUsersTargeting(redis)
+	if err != nil {
+		return err
+	}
+
+	meta := metav1.ObjectMeta{
+		Name:      redis.ConfigMapName(),
+		Namespace: redis.Namespace,
+	}
+	owner := metav1.NewControllerRef(redis, api.SchemeGroupVersion.WithKind(api.ResourceKindRedis))
+
+	aclUsersFile, err := c.renderACLUsersFile(redisUsers)
+	if err != nil {
+		return err
+	}
+
+	_, vt, err := core_util.CreateOrPatchConfigMap(context.TODO(), c.Client, meta, func(in *core.ConfigMap) *core.ConfigMap {
+		in.Labels = core_util.UpsertMap(in.Labels, redis.OffshootLabels())
+		core_util.EnsureOwnerReference(&in.ObjectMeta, owner)
+
+		if in.Data == nil {
+			in.Data = make(map[string]string)
+		}
+		in.Data[redisConfigFileName] = renderRedisConf()
+		in.Data[aclUsersFileName] = aclUsersFile
+		return in
+	}, metav1.PatchOptions{})
+	if err != nil {
+		return err
+	}
+	if vt != kutil.VerbUnchanged {
+		log.Infof("%s redis configmap %s/%s", vt, redis.Namespace, redis.Name)
+	}
+	return nil
+}
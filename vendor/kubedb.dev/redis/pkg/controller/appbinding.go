@@ -0,0 +1,113 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha2"
+
+	"github.com/appscode/go/log"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kutil "kmodules.xyz/client-go"
+)
+
+// appBindingGVR points at the appcatalog.appscode.com AppBinding CRD.
+// AppBinding isn't vendored as a typed API in this tree, so ensureAppBinding
+// goes through the dynamic client instead, the same way
+// resolveRedisVersionImage (sentinel.go) reaches a catalog CRD it has no
+// generated clientset for.
+var appBindingGVR = schema.GroupVersionResource{
+	Group:    "appcatalog.appscode.com",
+	Version:  "v1alpha1",
+	Resource: "appbindings",
+}
+
+// ensureAppBinding keeps an AppBinding in sync so tools outside this
+// operator (stash, cli) can discover how to connect to redis without
+// knowing its mode. In Sentinel mode that's the sentinel governing service
+// and the master name sentinels monitor under, since the data endpoint
+// itself moves during a failover; in every other mode it's the regular
+// database Service.
+func (c *Controller) ensureAppBinding(redis *api.Redis) (kutil.VerbType, error) {
+	owner := metav1.NewControllerRef(redis, api.SchemeGroupVersion.WithKind(api.ResourceKindRedis))
+
+	serviceName := redis.ServiceName()
+	port := int64(6379)
+	parameters := map[string]interface{}{}
+	if redis.Spec.Mode == api.RedisModeSentinel {
+		serviceName = redis.SentinelGoverningServiceName()
+		port = SentinelPort
+		parameters["masterName"] = redis.Name
+	}
+
+	existing, err := c.DynamicClient.Resource(appBindingGVR).Namespace(redis.Namespace).Get(context.TODO(), redis.Name, metav1.GetOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return kutil.VerbUnchanged, fmt.Errorf("failed to read AppBinding %s/%s: %w", redis.Namespace, redis.Name, err)
+	}
+
+	appBinding := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "appcatalog.appscode.com/v1alpha1",
+			"kind":       "AppBinding",
+			"metadata": map[string]interface{}{
+				"name":      redis.Name,
+				"namespace": redis.Namespace,
+				"labels":    redis.OffshootLabels(),
+				"ownerReferences": []interface{}{
+					map[string]interface{}{
+						"apiVersion":         owner.APIVersion,
+						"kind":               owner.Kind,
+						"name":               owner.Name,
+						"uid":                string(owner.UID),
+						"controller":         *owner.Controller,
+						"blockOwnerDeletion": *owner.BlockOwnerDeletion,
+					},
+				},
+			},
+			"spec": map[string]interface{}{
+				"clientConfig": map[string]interface{}{
+					"service": map[string]interface{}{
+						"name": serviceName,
+						"port": port,
+					},
+				},
+				"parameters": parameters,
+			},
+		},
+	}
+
+	verb := kutil.VerbCreated
+	if existing != nil {
+		appBinding.SetResourceVersion(existing.GetResourceVersion())
+		verb = kutil.VerbPatched
+		if _, err := c.DynamicClient.Resource(appBindingGVR).Namespace(redis.Namespace).Update(context.TODO(), appBinding, metav1.UpdateOptions{}); err != nil {
+			return kutil.VerbUnchanged, fmt.Errorf("failed to update AppBinding %s/%s: %w", redis.Namespace, redis.Name, err)
+		}
+	} else {
+		if _, err := c.DynamicClient.Resource(appBindingGVR).Namespace(redis.Namespace).Create(context.TODO(), appBinding, metav1.CreateOptions{}); err != nil {
+			return kutil.VerbUnchanged, fmt.Errorf("failed to create AppBinding %s/%s: %w", redis.Namespace, redis.Name, err)
+		}
+	}
+
+	log.Infof("%s AppBinding %s/%s", verb, redis.Namespace, redis.Name)
+	return verb, nil
+}
@@ -0,0 +1,298 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha2"
+	"kubedb.dev/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha2/util"
+
+	"github.com/appscode/go/log"
+	core "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kmapi "kmodules.xyz/client-go/api/v1"
+)
+
+const (
+	// ClusterUpgradeInProgress is set on redis.Status.Conditions while a
+	// pod-by-pod upgrade of a cluster-mode Redis is draining primaries and
+	// rolling pods, so `create` can keep the phase at Provisioning instead
+	// of flapping to Ready between shards.
+	ClusterUpgradeInProgress api.DatabaseConditionType = "ClusterUpgradeInProgress"
+
+	clusterUpgradePollInterval = 5 * time.Second
+	clusterUpgradePollTimeout  = 10 * time.Minute
+)
+
+// redisCommander is the seam upgradeRedisCluster talks to the cluster
+// through, so tests can substitute a fake dispatcher instead of opening
+// real connections to pods.
+type redisCommander interface {
+	ClusterNodes(ctx context.Context, pod string) (string, error)
+	ClusterFailover(ctx context.Context, pod string) error
+	Role(ctx context.Context, pod string) (string, error)
+	ClusterInfo(ctx context.Context, pod string) (map[string]string, error)
+}
+
+// upgradeRedisCluster is invoked from maybeUpgradeRedisCluster, itself
+// called from create() whenever the observed pod template revision of a
+// RedisModeCluster StatefulSet differs from the desired one. Instead of
+// handing the rollout to the StatefulSet's default RollingUpdate strategy
+// (which would happily kill a primary and strand its slots until Redis
+// Cluster re-elects a replica on its own schedule), it walks the shards one
+// pod at a time: failing a primary over to a replica *before* deleting its
+// pod, then waiting for the replacement to rejoin the cluster before moving
+// on. ClusterUpgradeInProgress is cleared once every shard has converged,
+// so it reflects an in-progress rollout and nothing past it.
+func (c *Controller) upgradeRedisCluster(redis *api.Redis, pods []core.Pod, cmd redisCommander) error {
+	expectedNodes := len(pods)
+
+	for shard, pod := range pods {
+		if err := c.setClusterUpgradeCondition(redis, shard, pod.Name); err != nil {
+			return err
+		}
+
+		isPrimary, replica, err := isPrimaryWithReplica(context.TODO(), cmd, pod.Name, pods)
+		if err != nil {
+			return err
+		}
+
+		if isPrimary && replica != "" {
+			c.Recorder.Eventf(redis, core.EventTypeNormal, "ClusterFailover",
+				"failing shard %d over from %s to %s before upgrade", shard, pod.Name, replica)
+
+			if err := cmd.ClusterFailover(context.TODO(), replica); err != nil {
+				return fmt.Errorf("failed to trigger CLUSTER FAILOVER on %s: %w", replica, err)
+			}
+			if err := waitUntilRole(context.TODO(), cmd, pod.Name, "slave"); err != nil {
+				return fmt.Errorf("shard %d: primary %s never stepped down after failover: %w", shard, pod.Name, err)
+			}
+		}
+
+		if err := c.Client.CoreV1().Pods(redis.Namespace).Delete(context.TODO(), pod.Name, metav1.DeleteOptions{}); err != nil {
+			return fmt.Errorf("failed to delete pod %s for rolling upgrade: %w", pod.Name, err)
+		}
+
+		if err := waitUntilClusterConverged(context.TODO(), cmd, pod.Name, expectedNodes); err != nil {
+			return fmt.Errorf("shard %d: cluster did not converge after replacing %s: %w", shard, pod.Name, err)
+		}
+
+		c.Recorder.Eventf(redis, core.EventTypeNormal, "ClusterUpgraded", "shard %d (pod %s) upgraded successfully", shard, pod.Name)
+	}
+
+	return c.clearClusterUpgradeCondition(redis)
+}
+
+// isPrimaryWithReplica parses `CLUSTER NODES` as seen from pod and reports
+// whether pod is currently a primary, and if so the name of a replica
+// whose CLUSTER NODES master id matches pod's own node id (not just any
+// other pod in the StatefulSet, which on a multi-shard cluster could
+// belong to an unrelated primary).
+func isPrimaryWithReplica(ctx context.Context, cmd redisCommander, pod string, pods []core.Pod) (bool, string, error) {
+	nodes, err := cmd.ClusterNodes(ctx, pod)
+	if err != nil {
+		return false, "", err
+	}
+
+	ipToPod := make(map[string]string, len(pods))
+	for _, p := range pods {
+		if p.Status.PodIP != "" {
+			ipToPod[p.Status.PodIP] = p.Name
+		}
+	}
+
+	lines := strings.Split(nodes, "\n")
+
+	var myID string
+	isPrimary := false
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		if strings.Contains(fields[2], "myself") {
+			myID = fields[0]
+			isPrimary = strings.Contains(fields[2], "master")
+			break
+		}
+	}
+	if !isPrimary {
+		return false, "", nil
+	}
+
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || !strings.Contains(fields[2], "slave") || fields[3] != myID {
+			continue
+		}
+		addr, _, _ := net.SplitHostPort(strings.SplitN(fields[1], "@", 2)[0])
+		if replica, ok := ipToPod[addr]; ok {
+			return true, replica, nil
+		}
+	}
+	return true, "", nil
+}
+
+func waitUntilRole(ctx context.Context, cmd redisCommander, pod, wantRole string) error {
+	return wait.PollImmediate(clusterUpgradePollInterval, clusterUpgradePollTimeout, func() (bool, error) {
+		role, err := cmd.Role(ctx, pod)
+		if err != nil {
+			return false, nil // keep polling; pod may be mid-failover
+		}
+		return strings.Contains(role, wantRole), nil
+	})
+}
+
+func waitUntilClusterConverged(ctx context.Context, cmd redisCommander, pod string, expectedNodes int) error {
+	return wait.PollImmediate(clusterUpgradePollInterval, clusterUpgradePollTimeout, func() (bool, error) {
+		info, err := cmd.ClusterInfo(ctx, pod)
+		if err != nil {
+			return false, nil
+		}
+		if info["cluster_state"] != "ok" {
+			return false, nil
+		}
+		return fmt.Sprintf("%d", expectedNodes) == info["cluster_known_nodes"], nil
+	})
+}
+
+// maybeUpgradeRedisCluster is invoked from create() (redis.go) right after
+// ensureRedisNodes for a RedisModeCluster Redis. It compares the
+// StatefulSet's currently-rolled-out pod template revision against the
+// desired one and, on drift, drives the shards through upgradeRedisCluster
+// instead of leaving the default StatefulSet RollingUpdate strategy to kill
+// a primary out from under its slots.
+func (c *Controller) maybeUpgradeRedisCluster(redis *api.Redis) error {
+	if redis.Spec.Mode != api.RedisModeCluster {
+		return nil
+	}
+
+	sts, err := c.Client.AppsV1().StatefulSets(redis.Namespace).Get(context.TODO(), redis.OffshootName(), metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if sts.Status.UpdateRevision == "" || sts.Status.CurrentRevision == sts.Status.UpdateRevision {
+		return nil
+	}
+
+	pods, err := c.Client.CoreV1().Pods(redis.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(redis.OffshootSelectors()).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list pods for Redis %s/%s: %w", redis.Namespace, redis.Name, err)
+	}
+
+	secret, err := c.Client.CoreV1().Secrets(redis.Namespace).Get(context.TODO(), c.GetRedisSecrets(redis)[0], metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to read admin credentials for Redis %s/%s: %w", redis.Namespace, redis.Name, err)
+	}
+
+	cmd := newLiveRedisCommander(redis, secret, newSPDYPodExecutor(c.ClientConfig, c.Client))
+	return c.upgradeRedisCluster(redis, pods.Items, cmd)
+}
+
+// liveRedisCommander is the production redisCommander: it execs
+// `redis-cli` into each pod, the same exec-based approach liveACLCommander
+// (redisuser.go) and refreshTLSCertsInPlace (tls_rotation.go) use.
+type liveRedisCommander struct {
+	redis    *api.Redis
+	password []byte
+	exec     podExecutor
+}
+
+func newLiveRedisCommander(redis *api.Redis, secret *core.Secret, exec podExecutor) redisCommander {
+	return &liveRedisCommander{redis: redis, password: secret.Data[core.BasicAuthPasswordKey], exec: exec}
+}
+
+func (l *liveRedisCommander) redisCLI(ctx context.Context, pod string, args ...string) (string, error) {
+	cmd := append([]string{"redis-cli", "-a", string(l.password), "--no-auth-warning"}, args...)
+	stdout, stderr, err := l.exec.Exec(ctx, l.redis.Namespace, pod, cmd)
+	if err != nil {
+		return "", fmt.Errorf("pod %s: %w (%s)", pod, err, stderr)
+	}
+	return stdout, nil
+}
+
+func (l *liveRedisCommander) ClusterNodes(ctx context.Context, pod string) (string, error) {
+	return l.redisCLI(ctx, pod, "CLUSTER", "NODES")
+}
+
+func (l *liveRedisCommander) ClusterFailover(ctx context.Context, pod string) error {
+	_, err := l.redisCLI(ctx, pod, "CLUSTER", "FAILOVER")
+	return err
+}
+
+func (l *liveRedisCommander) Role(ctx context.Context, pod string) (string, error) {
+	return l.redisCLI(ctx, pod, "ROLE")
+}
+
+func (l *liveRedisCommander) ClusterInfo(ctx context.Context, pod string) (map[string]string, error) {
+	out, err := l.redisCLI(ctx, pod, "CLUSTER", "INFO")
+	if err != nil {
+		return nil, err
+	}
+	info := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if ok {
+			info[key] = value
+		}
+	}
+	return info, nil
+}
+
+func (c *Controller) setClusterUpgradeCondition(redis *api.Redis, shard int, pod string) error {
+	_, err := util.UpdateRedisStatus(context.TODO(), c.DBClient.KubedbV1alpha2(), redis.ObjectMeta, func(in *api.RedisStatus) *api.RedisStatus {
+		in.Phase = api.DatabasePhaseProvisioning
+		in.Conditions = kmapi.SetCondition(in.Conditions, kmapi.Condition{
+			Type:    ClusterUpgradeInProgress,
+			Status:  core.ConditionTrue,
+			Reason:  "RollingUpgrade",
+			Message: fmt.Sprintf("upgrading shard %d (pod %s)", shard, pod),
+		})
+		return in
+	}, metav1.UpdateOptions{})
+	if err != nil {
+		log.Errorf("failed to record cluster upgrade progress for shard %d: %v", shard, err)
+	}
+	return err
+}
+
+// clearClusterUpgradeCondition removes ClusterUpgradeInProgress once
+// upgradeRedisCluster has walked every shard successfully, so `kubectl get
+// redis` doesn't keep showing an upgrade in progress long after it finished.
+func (c *Controller) clearClusterUpgradeCondition(redis *api.Redis) error {
+	_, err := util.UpdateRedisStatus(context.TODO(), c.DBClient.KubedbV1alpha2(), redis.ObjectMeta, func(in *api.RedisStatus) *api.RedisStatus {
+		in.Conditions = kmapi.RemoveCondition(in.Conditions, ClusterUpgradeInProgress)
+		return in
+	}, metav1.UpdateOptions{})
+	if err != nil {
+		log.Errorf("failed to clear cluster upgrade condition for Redis %s/%s: %v", redis.Namespace, redis.Name, err)
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeRedisCommander is the redisCommander seam's test double: ClusterNodes,
+// Role and ClusterInfo return canned per-pod output, and ClusterFailover
+// records the pods it was called on.
+type fakeRedisCommander struct {
+	clusterNodes map[string]string
+	failovers    []string
+	roles        map[string]string
+	clusterInfo  map[string]map[string]string
+}
+
+func (f *fakeRedisCommander) ClusterNodes(_ context.Context, pod string) (string, error) {
+	out, ok := f.clusterNodes[pod]
+	if !ok {
+		return "", fmt.Errorf("no CLUSTER NODES fixture for pod %s", pod)
+	}
+	return out, nil
+}
+
+func (f *fakeRedisCommander) ClusterFailover(_ context.Context, pod string) error {
+	f.failovers = append(f.failovers, pod)
+	return nil
+}
+
+func (f *fakeRedisCommander) Role(_ context.Context, pod string) (string, error) {
+	return f.roles[pod], nil
+}
+
+func (f *fakeRedisCommander) ClusterInfo(_ context.Context, pod string) (map[string]string, error) {
+	return f.clusterInfo[pod], nil
+}
+
+func TestIsPrimaryWithReplicaMatchesOwnShard(t *testing.T) {
+	// Two shards of a 4-pod cluster: pod-0 (primary) / pod-1 (its replica),
+	// and pod-2 (primary) / pod-3 (its replica). pod-0's CLUSTER NODES view
+	// must resolve pod-1, never pod-2 or pod-3.
+	nodes := "" +
+		"aaaa 10.0.0.1:6379@16379 myself,master - 0 0 1 connected 0-5460\n" +
+		"bbbb 10.0.0.2:6379@16379 slave aaaa 0 0 1 connected\n" +
+		"cccc 10.0.0.3:6379@16379 master - 0 0 2 connected 5461-10922\n" +
+		"dddd 10.0.0.4:6379@16379 slave cccc 0 0 2 connected\n"
+
+	cmd := &fakeRedisCommander{clusterNodes: map[string]string{"pod-0": nodes}}
+	pods := []core.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "pod-0"}, Status: core.PodStatus{PodIP: "10.0.0.1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "pod-1"}, Status: core.PodStatus{PodIP: "10.0.0.2"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "pod-2"}, Status: core.PodStatus{PodIP: "10.0.0.3"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "pod-3"}, Status: core.PodStatus{PodIP: "10.0.0.4"}},
+	}
+
+	isPrimary, replica, err := isPrimaryWithReplica(context.TODO(), cmd, "pod-0", pods)
+	if err != nil {
+		t.Fatalf("isPrimaryWithReplica failed: %v", err)
+	}
+	if !isPrimary {
+		t.Fatal("expected pod-0 to be reported as primary")
+	}
+	if replica != "pod-1" {
+		t.Fatalf("expected replica pod-1 (same shard as pod-0), got %q", replica)
+	}
+}
+
+func TestIsPrimaryWithReplicaReportsFalseForReplica(t *testing.T) {
+	nodes := "" +
+		"aaaa 10.0.0.1:6379@16379 master - 0 0 1 connected 0-5460\n" +
+		"bbbb 10.0.0.2:6379@16379 myself,slave aaaa 0 0 1 connected\n"
+
+	cmd := &fakeRedisCommander{clusterNodes: map[string]string{"pod-1": nodes}}
+	pods := []core.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "pod-0"}, Status: core.PodStatus{PodIP: "10.0.0.1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "pod-1"}, Status: core.PodStatus{PodIP: "10.0.0.2"}},
+	}
+
+	isPrimary, _, err := isPrimaryWithReplica(context.TODO(), cmd, "pod-1", pods)
+	if err != nil {
+		t.Fatalf("isPrimaryWithReplica failed: %v", err)
+	}
+	if isPrimary {
+		t.Fatal("expected pod-1 (a slave) not to be reported as primary")
+	}
+}
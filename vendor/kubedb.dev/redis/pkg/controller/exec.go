@@ -0,0 +1,71 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// spdyPodExecutor is the production podExecutor (see tls_rotation.go):
+// it runs a command inside a pod over the SPDY exec subprotocol, the same
+// mechanism `kubectl exec` uses. liveACLCommander and liveRedisCommander
+// are built on it to drive `redis-cli` inside the target pods instead of
+// dialing Redis directly, so TLS-enabled Redises need no extra client-side
+// certificate handling here.
+type spdyPodExecutor struct {
+	config *rest.Config
+	client kubernetes.Interface
+}
+
+// newSPDYPodExecutor returns a podExecutor that execs into pods using
+// config/client, normally the Controller's own ClientConfig and Client.
+func newSPDYPodExecutor(config *rest.Config, client kubernetes.Interface) podExecutor {
+	return &spdyPodExecutor{config: config, client: client}
+}
+
+func (e *spdyPodExecutor) Exec(ctx context.Context, namespace, pod string, command []string) (stdout, stderr string, err error) {
+	req := e.client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(pod).
+		Namespace(namespace).
+		SubResource("exec").
+		VersionedParams(&core.PodExecOptions{
+			Command: command,
+			Stdout:  true,
+			Stderr:  true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(e.config, "POST", req.URL())
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create executor for pod %s: %w", pod, err)
+	}
+
+	var outBuf, errBuf bytes.Buffer
+	err = executor.Stream(remotecommand.StreamOptions{
+		Stdout: &outBuf,
+		Stderr: &errBuf,
+	})
+	return outBuf.String(), errBuf.String(), err
+}
@@ -20,7 +20,6 @@ import (
 	"context"
 
 	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha2"
-	"kubedb.dev/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha2/util"
 	"kubedb.dev/apimachinery/pkg/eventer"
 	validator "kubedb.dev/redis/pkg/admission"
 
@@ -47,10 +46,10 @@ func (c *Controller) create(redis *api.Redis) error {
 	}
 
 	if redis.Status.Phase == "" {
-		rd, err := util.UpdateRedisStatus(context.TODO(), c.DBClient.KubedbV1alpha2(), redis.ObjectMeta, func(in *api.RedisStatus) *api.RedisStatus {
+		rd, err := c.updateRedisStatusWithRetry(redis.ObjectMeta, func(in *api.RedisStatus) *api.RedisStatus {
 			in.Phase = api.DatabasePhaseProvisioning
 			return in
-		}, metav1.UpdateOptions{})
+		})
 		if err != nil {
 			return err
 		}
@@ -64,10 +63,15 @@ func (c *Controller) create(redis *api.Redis) error {
 	}
 
 	// ensure ConfigMap for redis configuration file (i.e. redis.conf)
-	if redis.Spec.Mode == api.RedisModeCluster {
+	switch redis.Spec.Mode {
+	case api.RedisModeCluster:
 		if err := c.ensureRedisConfig(redis); err != nil {
 			return err
 		}
+	case api.RedisModeSentinel:
+		if err := c.ensureSentinelConfig(redis); err != nil {
+			return err
+		}
 	}
 
 	// Ensure ClusterRoles for statefulsets
@@ -101,11 +105,26 @@ func (c *Controller) create(redis *api.Redis) error {
 	}
 
 	// ensure database StatefulSet
-	vt2, err := c.ensureRedisNodes(redis)
+	var vt2 kutil.VerbType
+	if redis.Spec.Mode == api.RedisModeSentinel {
+		vt2, err = c.ensureSentinelNodes(redis)
+	} else {
+		vt2, err = c.ensureRedisNodes(redis)
+	}
 	if err != nil {
 		return err
 	}
 
+	if redis.Spec.Mode == api.RedisModeCluster {
+		if err := c.maybeUpgradeRedisCluster(redis); err != nil {
+			return err
+		}
+	}
+
+	if err := c.maybeRefreshTLSCertsInPlace(redis); err != nil {
+		return err
+	}
+
 	if vt1 == kutil.VerbCreated && vt2 == kutil.VerbCreated {
 		c.Recorder.Event(
 			redis,
@@ -145,11 +164,11 @@ func (c *Controller) create(redis *api.Redis) error {
 		}
 	}
 
-	rd, err := util.UpdateRedisStatus(context.TODO(), c.DBClient.KubedbV1alpha2(), redis.ObjectMeta, func(in *api.RedisStatus) *api.RedisStatus {
+	rd, err := c.updateRedisStatusWithRetry(redis.ObjectMeta, func(in *api.RedisStatus) *api.RedisStatus {
 		in.Phase = api.DatabasePhaseReady
 		in.ObservedGeneration = redis.Generation
 		return in
-	}, metav1.UpdateOptions{})
+	})
 	if err != nil {
 		c.Recorder.Eventf(
 			redis,
@@ -201,11 +220,11 @@ func (c *Controller) halt(db *api.Redis) error {
 		return err
 	}
 	log.Infof("update status of Redis %v/%v to Halted.", db.Namespace, db.Name)
-	if _, err := util.UpdateRedisStatus(context.TODO(), c.DBClient.KubedbV1alpha2(), db.ObjectMeta, func(in *api.RedisStatus) *api.RedisStatus {
+	if _, err := c.updateRedisStatusWithRetry(db.ObjectMeta, func(in *api.RedisStatus) *api.RedisStatus {
 		in.Phase = api.DatabasePhaseHalted
 		in.ObservedGeneration = db.Generation
 		return in
-	}, metav1.UpdateOptions{}); err != nil {
+	}); err != nil {
 		return err
 	}
 	return nil
@@ -0,0 +1,229 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"kubedb.dev/apimachinery/pkg/eventer"
+	validator "kubedb.dev/redis/pkg/admission"
+
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha2"
+	"kubedb.dev/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha2/util"
+
+	"github.com/appscode/go/log"
+	core "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// aclCommander is the seam runRedisUser talks to the target Redis through,
+// mirroring redisCommander in cluster_upgrade.go. Its production
+// implementation, liveACLCommander, execs redis-cli through a podExecutor,
+// which is what the tests substitute with a fake.
+type aclCommander interface {
+	ACLSetUser(ctx context.Context, username string, rules []string) error
+	ACLDelUser(ctx context.Context, username string) error
+}
+
+func (c *Controller) runRedisUser(key string) error {
+	log.Debugln("started processing, key:", key)
+	obj, exists, err := c.rdUserInformer.GetIndexer().GetByKey(key)
+	if err != nil {
+		log.Errorf("Fetching object with key %s from store failed with %v", key, err)
+		return err
+	}
+
+	if !exists {
+		log.Debugf("RedisUser %s does not exist anymore", key)
+		return nil
+	}
+
+	redisUser := obj.(*api.RedisUser).DeepCopy()
+	if redisUser.DeletionTimestamp != nil {
+		return c.deleteRedisUser(redisUser)
+	}
+	return c.reconcileRedisUser(redisUser)
+}
+
+// reconcileRedisUser looks up the target Redis, connects using the
+// operator's admin credentials and issues ACL SETUSER to bring the live
+// user in sync with the RedisUser spec. Results, including a hash of the
+// last-applied rule set, are recorded on the RedisUser status so that a
+// no-op reconcile (spec unchanged) doesn't re-issue the ACL command.
+func (c *Controller) reconcileRedisUser(redisUser *api.RedisUser) error {
+	if err := validator.ValidateRedisUser(c.Client, c.DBClient, redisUser); err != nil {
+		c.Recorder.Event(redisUser, core.EventTypeWarning, eventer.EventReasonInvalid, err.Error())
+		log.Errorln(err)
+		return nil // user error so just record error and don't retry.
+	}
+
+	redis, err := c.rdLister.Redises(redisUser.Namespace).Get(redisUser.Spec.TargetRedisRef.Name)
+	if err != nil {
+		return c.pushRedisUserFailure(redisUser, fmt.Errorf("target Redis %s/%s not found: %w", redisUser.Namespace, redisUser.Spec.TargetRedisRef.Name, err))
+	}
+
+	passwordSecret, err := c.Client.CoreV1().Secrets(redisUser.Namespace).Get(context.TODO(), redisUser.Spec.PasswordSecretRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return c.pushRedisUserFailure(redisUser, fmt.Errorf("failed to read password secret %s/%s for RedisUser %s: %w", redisUser.Namespace, redisUser.Spec.PasswordSecretRef.Name, redisUser.Name, err))
+	}
+
+	rules := buildACLRules(redisUser, passwordSecret.Data[core.BasicAuthPasswordKey])
+	hash := hashACLRules(rules)
+	if redisUser.Status.ObservedACLHash == hash {
+		return nil
+	}
+
+	cmd, err := c.adminACLCommanderFor(redis)
+	if err != nil {
+		return c.pushRedisUserFailure(redisUser, err)
+	}
+
+	if err := cmd.ACLSetUser(context.TODO(), redisUser.Spec.Username, rules); err != nil {
+		return c.pushRedisUserFailure(redisUser, fmt.Errorf("ACL SETUSER %s failed: %w", redisUser.Spec.Username, err))
+	}
+
+	_, err = util.UpdateRedisUserStatus(context.TODO(), c.DBClient.KubedbV1alpha2(), redisUser.ObjectMeta, func(in *api.RedisUserStatus) *api.RedisUserStatus {
+		in.Phase = api.RedisUserPhaseActive
+		in.ObservedACLHash = hash
+		in.Reason = ""
+		return in
+	}, metav1.UpdateOptions{})
+	return err
+}
+
+func (c *Controller) deleteRedisUser(redisUser *api.RedisUser) error {
+	redis, err := c.rdLister.Redises(redisUser.Namespace).Get(redisUser.Spec.TargetRedisRef.Name)
+	if kerrors.IsNotFound(err) {
+		// Target Redis is already gone; nothing live to clean up.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cmd, err := c.adminACLCommanderFor(redis)
+	if err != nil {
+		return err
+	}
+	return cmd.ACLDelUser(context.TODO(), redisUser.Spec.Username)
+}
+
+func (c *Controller) pushRedisUserFailure(redisUser *api.RedisUser, cause error) error {
+	log.Errorln(cause)
+	_, err := util.UpdateRedisUserStatus(context.TODO(), c.DBClient.KubedbV1alpha2(), redisUser.ObjectMeta, func(in *api.RedisUserStatus) *api.RedisUserStatus {
+		in.Phase = api.RedisUserPhaseFailed
+		in.Reason = cause.Error()
+		return in
+	}, metav1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+	return cause
+}
+
+// buildACLRules translates a RedisUser spec into the rule tokens ACL
+// SETUSER expects, in the order Redis documents them: on/off, a password (or
+// nopass), resetkeys/-@all to clear the slate, then the key patterns,
+// channel patterns and allowed commands to grant. resetkeys must come
+// before the `~pattern` tokens - issued in the other order it wipes out the
+// key access that was just granted.
+func buildACLRules(redisUser *api.RedisUser, password []byte) []string {
+	rules := []string{"on"}
+	if len(password) == 0 {
+		rules = append(rules, "nopass")
+	} else {
+		rules = append(rules, ">"+string(password))
+	}
+	rules = append(rules, "resetkeys", "-@all")
+	for _, pattern := range redisUser.Spec.KeyPatterns {
+		rules = append(rules, "~"+pattern)
+	}
+	for _, channel := range redisUser.Spec.Channels {
+		rules = append(rules, "&"+channel)
+	}
+	for _, command := range redisUser.Spec.AllowedCommands {
+		rules = append(rules, "+"+command)
+	}
+	return rules
+}
+
+func hashACLRules(rules []string) string {
+	h := sha256.New()
+	for _, rule := range rules {
+		h.Write([]byte(rule))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// adminACLCommanderFor builds an aclCommander that execs into every pod of
+// the given Redis using the operator's admin
+// credentials, the same secret GetRedisSecrets resolves for offshoot
+// cleanup, so RedisUser reconciliation needs no credentials of its own.
+func (c *Controller) adminACLCommanderFor(redis *api.Redis) (aclCommander, error) {
+	secret, err := c.Client.CoreV1().Secrets(redis.Namespace).Get(context.TODO(), c.GetRedisSecrets(redis)[0], metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read admin credentials for Redis %s/%s: %w", redis.Namespace, redis.Name, err)
+	}
+
+	pods, err := c.Client.CoreV1().Pods(redis.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(redis.OffshootSelectors()).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods for Redis %s/%s: %w", redis.Namespace, redis.Name, err)
+	}
+
+	return newLiveACLCommander(redis, pods.Items, secret, newSPDYPodExecutor(c.ClientConfig, c.Client)), nil
+}
+
+// liveACLCommander is the production aclCommander. Since Redis 6 doesn't
+// replicate ACL SETUSER/DELUSER to followers, it execs `redis-cli ACL ...`
+// into every pod of the target Redis rather than a single connection, the
+// same exec-based approach refreshTLSCertsInPlace (tls_rotation.go) uses.
+type liveACLCommander struct {
+	redis    *api.Redis
+	pods     []core.Pod
+	password []byte
+	exec     podExecutor
+}
+
+func newLiveACLCommander(redis *api.Redis, pods []core.Pod, secret *core.Secret, exec podExecutor) aclCommander {
+	return &liveACLCommander{redis: redis, pods: pods, password: secret.Data[core.BasicAuthPasswordKey], exec: exec}
+}
+
+func (l *liveACLCommander) ACLSetUser(ctx context.Context, username string, rules []string) error {
+	cmd := append([]string{"redis-cli", "-a", string(l.password), "--no-auth-warning", "ACL", "SETUSER", username}, rules...)
+	return l.runOnEveryPod(ctx, cmd)
+}
+
+func (l *liveACLCommander) ACLDelUser(ctx context.Context, username string) error {
+	return l.runOnEveryPod(ctx, []string{"redis-cli", "-a", string(l.password), "--no-auth-warning", "ACL", "DELUSER", username})
+}
+
+func (l *liveACLCommander) runOnEveryPod(ctx context.Context, cmd []string) error {
+	for _, pod := range l.pods {
+		if _, stderr, err := l.exec.Exec(ctx, l.redis.Namespace, pod.Name, cmd); err != nil {
+			return fmt.Errorf("pod %s: %w (%s)", pod.Name, err, stderr)
+		}
+	}
+	return nil
+}
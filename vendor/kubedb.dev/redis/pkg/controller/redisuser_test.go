@@ -0,0 +1,129 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha2"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakePodExecutor is the podExecutor test double liveACLCommander is tested
+// through, recording every command it was asked to run instead of opening a
+// real exec stream.
+type fakePodExecutor struct {
+	commands [][]string
+	failPod  string
+}
+
+func (f *fakePodExecutor) Exec(_ context.Context, _, pod string, command []string) (string, string, error) {
+	f.commands = append(f.commands, command)
+	if pod == f.failPod {
+		return "", "NOPERM", errors.New("exec failed")
+	}
+	return "OK", "", nil
+}
+
+func TestLiveACLCommanderSetUserDispatchesToEveryPod(t *testing.T) {
+	redis := &api.Redis{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "redis-demo"}}
+	pods := []core.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "redis-demo-0"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "redis-demo-1"}},
+	}
+	exec := &fakePodExecutor{}
+	cmd := newLiveACLCommander(redis, pods, &core.Secret{Data: map[string][]byte{core.BasicAuthPasswordKey: []byte("s3cr3t")}}, exec)
+
+	if err := cmd.ACLSetUser(context.TODO(), "reader", []string{"on", "~foo:*", "+get"}); err != nil {
+		t.Fatalf("ACLSetUser failed: %v", err)
+	}
+	if len(exec.commands) != 2 {
+		t.Fatalf("expected ACL SETUSER to run on both pods, ran on %d", len(exec.commands))
+	}
+	for _, command := range exec.commands {
+		if command[0] != "redis-cli" || command[len(command)-1] != "+get" {
+			t.Errorf("unexpected command: %v", command)
+		}
+	}
+}
+
+func TestLiveACLCommanderSetUserFailsOnFirstPodError(t *testing.T) {
+	redis := &api.Redis{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "redis-demo"}}
+	pods := []core.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "redis-demo-0"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "redis-demo-1"}},
+	}
+	exec := &fakePodExecutor{failPod: "redis-demo-0"}
+	cmd := newLiveACLCommander(redis, pods, &core.Secret{}, exec)
+
+	if err := cmd.ACLSetUser(context.TODO(), "reader", []string{"on"}); err == nil {
+		t.Fatal("expected an error when a pod's exec fails, got nil")
+	}
+}
+
+func TestBuildACLRules(t *testing.T) {
+	redisUser := &api.RedisUser{Spec: api.RedisUserSpec{
+		KeyPatterns:     []string{"foo:*"},
+		Channels:        []string{"news"},
+		AllowedCommands: []string{"get", "set"},
+	}}
+	rules := buildACLRules(redisUser, []byte("s3cr3t"))
+	want := []string{"on", ">s3cr3t", "resetkeys", "-@all", "~foo:*", "&news", "+get", "+set"}
+	if len(rules) != len(want) {
+		t.Fatalf("got %v, want %v", rules, want)
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Fatalf("got %v, want %v", rules, want)
+		}
+	}
+}
+
+func TestBuildACLRulesResetkeysPrecedesKeyPatterns(t *testing.T) {
+	// resetkeys must come before the ~pattern grants: ACL SETUSER applies
+	// rules left to right, so resetkeys issued after ~foo:* would wipe out
+	// the key access that was just granted.
+	redisUser := &api.RedisUser{Spec: api.RedisUserSpec{KeyPatterns: []string{"foo:*"}}}
+	rules := buildACLRules(redisUser, nil)
+
+	resetIdx, patternIdx := -1, -1
+	for i, rule := range rules {
+		switch rule {
+		case "resetkeys":
+			resetIdx = i
+		case "~foo:*":
+			patternIdx = i
+		}
+	}
+	if resetIdx == -1 || patternIdx == -1 {
+		t.Fatalf("expected both resetkeys and ~foo:* in rules, got %v", rules)
+	}
+	if resetIdx > patternIdx {
+		t.Fatalf("expected resetkeys (%d) before ~foo:* (%d), got %v", resetIdx, patternIdx, rules)
+	}
+}
+
+func TestBuildACLRulesNopassWhenNoPassword(t *testing.T) {
+	rules := buildACLRules(&api.RedisUser{}, nil)
+	if len(rules) < 2 || rules[1] != "nopass" {
+		t.Fatalf("expected nopass when no password is supplied, got %v", rules)
+	}
+}
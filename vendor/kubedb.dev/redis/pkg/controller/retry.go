@@ -0,0 +1,78 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha2"
+	"kubedb.dev/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha2/util"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// retryOnConflict runs fn, retrying with retry.DefaultBackoff for as long as
+// fn keeps returning a conflict error. It's split out from
+// updateRedisStatusWithRetry/patchRedisWithRetry so the retry behavior
+// itself - converging once fn starts succeeding, and propagating the last
+// error once the backoff is exhausted - can be exercised directly in tests.
+func retryOnConflict(fn func() error) error {
+	return retry.OnError(retry.DefaultBackoff, kerrors.IsConflict, fn)
+}
+
+// updateRedisStatusWithRetry wraps util.UpdateRedisStatus so a conflicting
+// write (another reconcile, or the informer cache lagging behind) doesn't
+// bubble straight up to the caller and get dropped as a requeue. On
+// IsConflict it re-reads the latest Redis from c.rdLister and re-applies
+// transform against it; retryOnConflict bounds the number of attempts, and
+// if it's exhausted the final conflict error is returned to the caller
+// rather than swallowed.
+func (c *Controller) updateRedisStatusWithRetry(meta metav1.ObjectMeta, transform func(*api.RedisStatus) *api.RedisStatus) (*api.Redis, error) {
+	var result *api.Redis
+	err := retryOnConflict(func() error {
+		var err error
+		result, err = util.UpdateRedisStatus(context.TODO(), c.DBClient.KubedbV1alpha2(), meta, transform, metav1.UpdateOptions{})
+		if kerrors.IsConflict(err) {
+			if latest, lerr := c.rdLister.Redises(meta.Namespace).Get(meta.Name); lerr == nil {
+				meta = latest.ObjectMeta
+			}
+		}
+		return err
+	})
+	return result, err
+}
+
+// patchRedisWithRetry is the patchRedis counterpart to
+// updateRedisStatusWithRetry, used by the finalizer add/remove sites in
+// runRedis which race with user-initiated spec updates just as often as
+// status writes race with other reconciles.
+func (c *Controller) patchRedisWithRetry(redis *api.Redis, transform func(*api.Redis) *api.Redis) (*api.Redis, error) {
+	var result *api.Redis
+	err := retryOnConflict(func() error {
+		var err error
+		result, _, err = util.PatchRedis(context.TODO(), c.DBClient.KubedbV1alpha2(), redis, transform, metav1.PatchOptions{})
+		if kerrors.IsConflict(err) {
+			if latest, lerr := c.rdLister.Redises(redis.Namespace).Get(redis.Name); lerr == nil {
+				redis = latest
+			}
+		}
+		return err
+	})
+	return result, err
+}
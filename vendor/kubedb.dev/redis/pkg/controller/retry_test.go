@@ -0,0 +1,60 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func conflictErr() error {
+	return kerrors.NewConflict(schema.GroupResource{Group: "kubedb.dev", Resource: "redises"}, "test-redis", errors.New("conflict"))
+}
+
+func TestRetryOnConflictConvergesAfterTransientConflicts(t *testing.T) {
+	attempts := 0
+	err := retryOnConflict(func() error {
+		attempts++
+		if attempts <= 2 {
+			return conflictErr()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected retryOnConflict to converge once the write stops conflicting, got %v", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("expected exactly 3 attempts (2 conflicts + 1 success), got %d", attempts)
+	}
+}
+
+func TestRetryOnConflictPropagatesExhaustedConflict(t *testing.T) {
+	attempts := 0
+	err := retryOnConflict(func() error {
+		attempts++
+		return conflictErr()
+	})
+	if err == nil || !kerrors.IsConflict(err) {
+		t.Fatalf("expected retryOnConflict to propagate the final conflict error once its backoff is exhausted, got %v", err)
+	}
+	if attempts == 0 {
+		t.Fatal("expected fn to have been called at least once")
+	}
+}
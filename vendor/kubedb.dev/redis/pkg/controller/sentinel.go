@@ -0,0 +1,345 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha2"
+
+	"github.com/appscode/go/log"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	kutil "kmodules.xyz/client-go"
+	app_util "kmodules.xyz/client-go/apps/v1"
+	core_util "kmodules.xyz/client-go/core/v1"
+	policy_util "kmodules.xyz/client-go/policy/v1beta1"
+)
+
+const (
+	// SentinelPort is the port sentinel listens on, as hard-coded by redis-sentinel itself.
+	SentinelPort = 26379
+
+	sentinelConfigFileName  = "sentinel.conf"
+	sentinelConfigMountPath = "/etc/redis/sentinel"
+
+	// sentinelConfigSourcePath is where the read-only ConfigMap is mounted;
+	// sentinelInitContainerName seeds sentinelConfigMountPath (an emptyDir)
+	// from it, since redis-sentinel issues CONFIG REWRITE at runtime and
+	// can't persist state back to a ConfigMap-backed, read-only mount.
+	sentinelConfigSourcePath  = "/etc/redis/sentinel-src"
+	sentinelInitContainerName = "init-sentinel-config"
+)
+
+// redisVersionGVR points at the catalog.kubedb.com RedisVersion CRD.
+// RedisVersion isn't vendored as a typed API in this tree, so
+// resolveRedisVersionImage goes through the dynamic client instead, the same
+// way setOwnerReferenceToOffshoots (redis.go) reaches resources it has no
+// generated clientset for.
+var redisVersionGVR = schema.GroupVersionResource{
+	Group:    "catalog.kubedb.com",
+	Version:  "v1alpha1",
+	Resource: "redisversions",
+}
+
+// resolveRedisVersionImage looks up the RedisVersion catalog entry named by
+// redis.Spec.Version and returns its spec.db.image, the pullable image the
+// catalog name maps to. redis.Spec.Version is a catalog name like "6.2.5",
+// never an image reference, so container specs must resolve it through the
+// catalog rather than using it as Image directly.
+func (c *Controller) resolveRedisVersionImage(redis *api.Redis) (string, error) {
+	rv, err := c.DynamicClient.Resource(redisVersionGVR).Get(context.TODO(), redis.Spec.Version, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to read RedisVersion catalog entry %q for Redis %s/%s: %w", redis.Spec.Version, redis.Namespace, redis.Name, err)
+	}
+	image, found, err := unstructured.NestedString(rv.UnstructuredContent(), "spec", "db", "image")
+	if err != nil || !found || image == "" {
+		return "", fmt.Errorf("RedisVersion catalog entry %q has no spec.db.image", redis.Spec.Version)
+	}
+	return image, nil
+}
+
+// ensureSentinelConfig renders the sentinel.conf (monitor stanza, quorum,
+// down-after-milliseconds, failover-timeout, parallel-syncs and auth-pass)
+// into a ConfigMap shared by every sentinel pod. Sentinels rewrite this file
+// at runtime as they learn about failovers, but the ConfigMap gives every
+// pod an identical starting point after a restart.
+func (c *Controller) ensureSentinelConfig(redis *api.Redis) error {
+	if redis.Spec.Sentinel == nil {
+		return fmt.Errorf("spec.sentinel is required for Redis %s/%s in Sentinel mode", redis.Namespace, redis.Name)
+	}
+
+	var password []byte
+	if ref := redis.Spec.Sentinel.AuthSecretRef; ref != nil {
+		secret, err := c.Client.CoreV1().Secrets(redis.Namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to read sentinel auth secret for Redis %s/%s: %w", redis.Namespace, redis.Name, err)
+		}
+		password = secret.Data[core.BasicAuthPasswordKey]
+	}
+
+	meta := metav1.ObjectMeta{
+		Name:      redis.ConfigMapName(),
+		Namespace: redis.Namespace,
+	}
+
+	owner := metav1.NewControllerRef(redis, api.SchemeGroupVersion.WithKind(api.ResourceKindRedis))
+
+	_, vt, err := core_util.CreateOrPatchConfigMap(context.TODO(), c.Client, meta, func(in *core.ConfigMap) *core.ConfigMap {
+		in.Labels = core_util.UpsertMap(in.Labels, redis.OffshootLabels())
+		core_util.EnsureOwnerReference(&in.ObjectMeta, owner)
+
+		if in.Data == nil {
+			in.Data = make(map[string]string)
+		}
+		in.Data[sentinelConfigFileName] = renderSentinelConf(redis, password)
+		return in
+	}, metav1.PatchOptions{})
+	if err != nil {
+		return err
+	}
+	if vt != kutil.VerbUnchanged {
+		log.Infof("%s sentinel configmap %s/%s", vt, redis.Namespace, redis.Name)
+	}
+	return nil
+}
+
+// renderSentinelConf builds the body of sentinel.conf for the given Redis
+// object. sentinel.conf is read verbatim by redis-sentinel, which performs
+// no shell/env expansion, so password, when non-nil, is the literal
+// plaintext read from the AuthSecretRef secret rather than a variable
+// reference.
+func renderSentinelConf(redis *api.Redis, password []byte) string {
+	sentinel := redis.Spec.Sentinel
+	masterName := redis.Name
+
+	downAfter := sentinel.DownAfterMilliseconds
+	if downAfter == 0 {
+		downAfter = 30000
+	}
+	failoverTimeout := sentinel.FailoverTimeout
+	if failoverTimeout == 0 {
+		failoverTimeout = 180000
+	}
+	parallelSyncs := sentinel.ParallelSyncs
+	if parallelSyncs == 0 {
+		parallelSyncs = 1
+	}
+
+	conf := fmt.Sprintf(`sentinel monitor %s %s 6379 %d
+sentinel down-after-milliseconds %s %d
+sentinel failover-timeout %s %d
+sentinel parallel-syncs %s %d
+`,
+		masterName, redis.ServiceName(), sentinel.Quorum,
+		masterName, downAfter,
+		masterName, failoverTimeout,
+		masterName, parallelSyncs,
+	)
+
+	if sentinel.AuthSecretRef != nil {
+		conf += fmt.Sprintf("sentinel auth-pass %s %s\n", masterName, password)
+	}
+	return conf
+}
+
+// ensureSentinelNodes ensures the StatefulSet, governing Service and
+// PodDisruptionBudget backing the sentinel fleet. Unlike ensureRedisNodes,
+// sentinels don't hold data so the StatefulSet uses no PVC template and the
+// readiness probe simply runs `sentinel ping` against the sentinel port.
+func (c *Controller) ensureSentinelNodes(redis *api.Redis) (kutil.VerbType, error) {
+	if err := c.ensureSentinelGoverningService(redis); err != nil {
+		return kutil.VerbUnchanged, err
+	}
+
+	owner := metav1.NewControllerRef(redis, api.SchemeGroupVersion.WithKind(api.ResourceKindRedis))
+
+	image, err := c.resolveRedisVersionImage(redis)
+	if err != nil {
+		return kutil.VerbUnchanged, err
+	}
+
+	meta := metav1.ObjectMeta{
+		Name:      redis.SentinelStatefulSetName(),
+		Namespace: redis.Namespace,
+	}
+
+	replicas := int32(3)
+	if redis.Spec.Sentinel.Replicas != nil {
+		replicas = *redis.Spec.Sentinel.Replicas
+	}
+
+	statefulSet, vt, err := app_util.CreateOrPatchStatefulSet(context.TODO(), c.Client, meta, func(in *apps.StatefulSet) *apps.StatefulSet {
+		in.Labels = core_util.UpsertMap(in.Labels, redis.OffshootLabels())
+		core_util.EnsureOwnerReference(&in.ObjectMeta, owner)
+
+		in.Spec.Replicas = &replicas
+		in.Spec.ServiceName = redis.SentinelGoverningServiceName()
+		in.Spec.Selector = &metav1.LabelSelector{
+			MatchLabels: redis.OffshootSelectors(),
+		}
+		in.Spec.Template.Labels = core_util.UpsertMap(in.Spec.Template.Labels, redis.OffshootSelectors())
+
+		// redis-sentinel rewrites sentinel.conf at runtime (CONFIG REWRITE) as
+		// it learns about failovers, which fails against a ConfigMap's
+		// read-only mount. The sentinelInitContainerName init container seeds a writable emptyDir from
+		// the ConfigMap once at pod start, and the sentinel container runs
+		// against that copy instead.
+		initContainer := core.Container{
+			Name:  sentinelInitContainerName,
+			Image: image,
+			Command: []string{
+				"sh", "-c",
+				fmt.Sprintf("cp %s/%s %s/%s", sentinelConfigSourcePath, sentinelConfigFileName, sentinelConfigMountPath, sentinelConfigFileName),
+			},
+			VolumeMounts: []core.VolumeMount{
+				{
+					Name:      "sentinel-config-src",
+					MountPath: sentinelConfigSourcePath,
+				},
+				{
+					Name:      "sentinel-config",
+					MountPath: sentinelConfigMountPath,
+				},
+			},
+		}
+
+		container := core.Container{
+			Name:  "sentinel",
+			Image: image,
+			Args: []string{
+				fmt.Sprintf("%s/%s", sentinelConfigMountPath, sentinelConfigFileName),
+				"--sentinel",
+			},
+			Ports: []core.ContainerPort{
+				{
+					Name:          "sentinel",
+					ContainerPort: SentinelPort,
+				},
+			},
+			VolumeMounts: []core.VolumeMount{
+				{
+					Name:      "sentinel-config",
+					MountPath: sentinelConfigMountPath,
+				},
+			},
+			ReadinessProbe: &core.Probe{
+				Handler: core.Handler{
+					Exec: &core.ExecAction{
+						Command: []string{
+							"redis-cli", "-p", fmt.Sprintf("%d", SentinelPort), "sentinel", "ping",
+						},
+					},
+				},
+				InitialDelaySeconds: 10,
+				PeriodSeconds:       10,
+			},
+		}
+		in.Spec.Template.Spec.InitContainers = core_util.UpsertContainer(in.Spec.Template.Spec.InitContainers, initContainer)
+		in.Spec.Template.Spec.Containers = core_util.UpsertContainer(in.Spec.Template.Spec.Containers, container)
+		in.Spec.Template.Spec.Volumes = core_util.UpsertVolume(in.Spec.Template.Spec.Volumes, core.Volume{
+			Name: "sentinel-config-src",
+			VolumeSource: core.VolumeSource{
+				ConfigMap: &core.ConfigMapVolumeSource{
+					LocalObjectReference: core.LocalObjectReference{
+						Name: redis.ConfigMapName(),
+					},
+				},
+			},
+		})
+		in.Spec.Template.Spec.Volumes = core_util.UpsertVolume(in.Spec.Template.Spec.Volumes, core.Volume{
+			Name: "sentinel-config",
+			VolumeSource: core.VolumeSource{
+				EmptyDir: &core.EmptyDirVolumeSource{},
+			},
+		})
+		return in
+	}, metav1.PatchOptions{})
+	if err != nil {
+		return kutil.VerbUnchanged, err
+	}
+
+	if err := c.ensureSentinelPodDisruptionBudget(redis, statefulSet); err != nil {
+		return kutil.VerbUnchanged, err
+	}
+
+	return vt, nil
+}
+
+func (c *Controller) ensureSentinelGoverningService(redis *api.Redis) error {
+	meta := metav1.ObjectMeta{
+		Name:      redis.SentinelGoverningServiceName(),
+		Namespace: redis.Namespace,
+	}
+	owner := metav1.NewControllerRef(redis, api.SchemeGroupVersion.WithKind(api.ResourceKindRedis))
+
+	_, vt, err := core_util.CreateOrPatchService(context.TODO(), c.Client, meta, func(in *core.Service) *core.Service {
+		in.Labels = core_util.UpsertMap(in.Labels, redis.OffshootLabels())
+		core_util.EnsureOwnerReference(&in.ObjectMeta, owner)
+
+		in.Spec.ClusterIP = core.ClusterIPNone
+		in.Spec.Selector = redis.OffshootSelectors()
+		in.Spec.Ports = core_util.MergeServicePorts(in.Spec.Ports, []core.ServicePort{
+			{
+				Name:       "sentinel",
+				Port:       SentinelPort,
+				TargetPort: intstr.FromInt(SentinelPort),
+			},
+		})
+		return in
+	}, metav1.PatchOptions{})
+	if err != nil {
+		return err
+	}
+	if vt != kutil.VerbUnchanged {
+		log.Infof("%s sentinel governing service %s/%s", vt, redis.Namespace, redis.Name)
+	}
+	return nil
+}
+
+func (c *Controller) ensureSentinelPodDisruptionBudget(redis *api.Redis, statefulSet *apps.StatefulSet) error {
+	meta := metav1.ObjectMeta{
+		Name:      redis.SentinelStatefulSetName(),
+		Namespace: redis.Namespace,
+	}
+	owner := metav1.NewControllerRef(redis, api.SchemeGroupVersion.WithKind(api.ResourceKindRedis))
+
+	maxUnavailable := intstr.FromInt(1)
+	_, vt, err := policy_util.CreateOrPatchPodDisruptionBudget(context.TODO(), c.Client, meta, func(in *policy.PodDisruptionBudget) *policy.PodDisruptionBudget {
+		in.Labels = core_util.UpsertMap(in.Labels, redis.OffshootLabels())
+		core_util.EnsureOwnerReference(&in.ObjectMeta, owner)
+
+		in.Spec.MaxUnavailable = &maxUnavailable
+		in.Spec.Selector = &metav1.LabelSelector{
+			MatchLabels: redis.OffshootSelectors(),
+		}
+		return in
+	}, metav1.PatchOptions{})
+	if err != nil {
+		return err
+	}
+	if vt != kutil.VerbUnchanged {
+		log.Infof("%s sentinel pod disruption budget %s/%s", vt, redis.Namespace, redis.Name)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha2"
+	"kubedb.dev/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha2/util"
+
+	"github.com/appscode/go/log"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// computeTLSCertHash hashes the three cert secrets together so
+// maybeRefreshTLSCertsInPlace can tell "cert content changed" apart from "pod
+// template changed" without diffing certificate bytes on every reconcile.
+func computeTLSCertHash(server, client, exporter *core.Secret) string {
+	h := sha256.New()
+	for _, secret := range []*core.Secret{server, client, exporter} {
+		if secret == nil {
+			continue
+		}
+		for _, key := range []string{core.TLSCertKey, core.TLSPrivateKeyKey} {
+			h.Write(secret.Data[key])
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// refreshTLSCertsInPlace is invoked from maybeRefreshTLSCertsInPlace when a
+// fresh computeTLSCertHash no longer matches redis.Status.TLSCertHash; the
+// pod template is not compared. Instead of mutating the StatefulSet (which would roll every
+// pod and drop client connections) it walks the pods one at a time and
+// hot-swaps the cert paths in place.
+func (c *Controller) refreshTLSCertsInPlace(redis *api.Redis, newHash string, pods []core.Pod, exec podExecutor) error {
+	for _, pod := range pods {
+		cmds := [][]string{
+			{"redis-cli", "CONFIG", "SET", "tls-cert-file", redisServerCertMountPath + "/" + core.TLSCertKey},
+			{"redis-cli", "CONFIG", "SET", "tls-key-file", redisServerCertMountPath + "/" + core.TLSPrivateKeyKey},
+			{"redis-cli", "CONFIG", "REWRITE"},
+		}
+		for _, cmd := range cmds {
+			if _, _, err := exec.Exec(context.TODO(), redis.Namespace, pod.Name, cmd); err != nil {
+				return fmt.Errorf("failed to refresh TLS certs on pod %s: %w", pod.Name, err)
+			}
+		}
+		log.Infof("refreshed TLS certs on pod %s/%s without restart", pod.Namespace, pod.Name)
+	}
+
+	_, err := util.UpdateRedisStatus(context.TODO(), c.DBClient.KubedbV1alpha2(), redis.ObjectMeta, func(in *api.RedisStatus) *api.RedisStatus {
+		in.TLSCertHash = newHash
+		return in
+	}, metav1.UpdateOptions{})
+	return err
+}
+
+// maybeRefreshTLSCertsInPlace is invoked from create() (redis.go) once the
+// StatefulSet itself is up to date. It recomputes computeTLSCertHash from
+// the three live cert secrets; a first-time hash is just recorded (nothing
+// to refresh on brand-new pods), but a hash that no longer matches
+// redis.Status.TLSCertHash means a cert-manager rotation happened without a
+// pod template change, so it hot-swaps the certs in place via
+// refreshTLSCertsInPlace instead of waiting on the next reconcile to notice.
+func (c *Controller) maybeRefreshTLSCertsInPlace(redis *api.Redis) error {
+	if redis.Spec.TLS == nil {
+		return nil
+	}
+
+	server, err := c.Client.CoreV1().Secrets(redis.Namespace).Get(context.TODO(), redis.MustCertSecretName(api.RedisServerCert), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	client, err := c.Client.CoreV1().Secrets(redis.Namespace).Get(context.TODO(), redis.MustCertSecretName(api.RedisClientCert), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	exporter, err := c.Client.CoreV1().Secrets(redis.Namespace).Get(context.TODO(), redis.MustCertSecretName(api.RedisMetricsExporterCert), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	newHash := computeTLSCertHash(server, client, exporter)
+	if redis.Status.TLSCertHash == "" {
+		_, err := c.updateRedisStatusWithRetry(redis.ObjectMeta, func(in *api.RedisStatus) *api.RedisStatus {
+			in.TLSCertHash = newHash
+			return in
+		})
+		return err
+	}
+	if newHash == redis.Status.TLSCertHash {
+		return nil
+	}
+
+	pods, err := c.Client.CoreV1().Pods(redis.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(redis.OffshootSelectors()).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list pods for Redis %s/%s: %w", redis.Namespace, redis.Name, err)
+	}
+
+	return c.refreshTLSCertsInPlace(redis, newHash, pods.Items, newSPDYPodExecutor(c.ClientConfig, c.Client))
+}
+
+// podExecutor is the seam refreshTLSCertsInPlace uses to run commands
+// inside a pod (normally the controller's REST client + SPDY executor), so
+// tests can substitute a fake without a real apiserver or kubelet.
+type podExecutor interface {
+	Exec(ctx context.Context, namespace, pod string, command []string) (stdout, stderr string, err error)
+}
+
+const redisServerCertMountPath = "/etc/redis/certs/server"
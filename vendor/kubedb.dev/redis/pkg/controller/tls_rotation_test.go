@@ -0,0 +1,41 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	core "k8s.io/api/core/v1"
+)
+
+func TestComputeTLSCertHashChangesWithCertContent(t *testing.T) {
+	server := &core.Secret{Data: map[string][]byte{core.TLSCertKey: []byte("cert-v1"), core.TLSPrivateKeyKey: []byte("key-v1")}}
+	client := &core.Secret{Data: map[string][]byte{core.TLSCertKey: []byte("client-cert"), core.TLSPrivateKeyKey: []byte("client-key")}}
+	exporter := &core.Secret{Data: map[string][]byte{core.TLSCertKey: []byte("exporter-cert"), core.TLSPrivateKeyKey: []byte("exporter-key")}}
+
+	before := computeTLSCertHash(server, client, exporter)
+
+	rotated := &core.Secret{Data: map[string][]byte{core.TLSCertKey: []byte("cert-v2"), core.TLSPrivateKeyKey: []byte("key-v2")}}
+	after := computeTLSCertHash(rotated, client, exporter)
+
+	if before == after {
+		t.Fatal("expected computeTLSCertHash to change when the server cert rotates")
+	}
+	if again := computeTLSCertHash(server, client, exporter); again != before {
+		t.Fatal("expected computeTLSCertHash to be deterministic for the same inputs")
+	}
+}
@@ -17,15 +17,13 @@ limitations under the License.
 package controller
 
 import (
-	"context"
-
 	"kubedb.dev/apimachinery/apis/kubedb"
 	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha2"
-	"kubedb.dev/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha2/util"
+	"kubedb.dev/apimachinery/pkg/eventer"
+	validator "kubedb.dev/redis/pkg/admission"
 
 	"gomodules.xyz/x/log"
 	core "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/cache"
 	kmapi "kmodules.xyz/client-go/api/v1"
 	core_util "kmodules.xyz/client-go/core/v1"
@@ -36,7 +34,57 @@ func (c *Controller) initWatcher() {
 	c.rdInformer = c.KubedbInformerFactory.Kubedb().V1alpha2().Redises().Informer()
 	c.rdQueue = queue.New("Redis", c.MaxNumRequeues, c.NumThreads, c.runRedis)
 	c.rdLister = c.KubedbInformerFactory.Kubedb().V1alpha2().Redises().Lister()
-	c.rdInformer.AddEventHandler(queue.NewChangeHandler(c.rdQueue.GetQueue()))
+	c.rdInformer.AddEventHandler(&redisModeTransitionGuard{
+		ResourceEventHandler: queue.NewChangeHandler(c.rdQueue.GetQueue()),
+		controller:           c,
+	})
+
+	c.initRedisUserWatcher()
+}
+
+// redisModeTransitionGuard wraps the Redis informer's change handler so an
+// update that flips spec.mode gets reverted instead of taking effect: by the
+// time the informer fires, the apiserver has already persisted the new
+// spec.mode, so merely dropping this event isn't enough - the next informer
+// event (old=new, under the new mode) would enqueue normally and reconcile
+// under a mode the object was never meant to allow. There is no admission
+// webhook in this binary to reject the write before it lands, so patching
+// spec.mode back to oldRedis's value is the nearest equivalent enforcement
+// point, using the informer's own pre-update cache entry as the source of
+// truth for what spec.mode must stay.
+type redisModeTransitionGuard struct {
+	cache.ResourceEventHandler
+	controller *Controller
+}
+
+func (g *redisModeTransitionGuard) OnUpdate(oldObj, newObj interface{}) {
+	oldRedis, ok := oldObj.(*api.Redis)
+	newRedis, ok2 := newObj.(*api.Redis)
+	if ok && ok2 {
+		if err := validator.ValidateRedisModeTransition(newRedis, oldRedis); err != nil {
+			g.controller.Recorder.Event(newRedis, core.EventTypeWarning, eventer.EventReasonInvalid, err.Error())
+			log.Errorln(err)
+			if _, perr := g.controller.patchRedisWithRetry(newRedis, func(in *api.Redis) *api.Redis {
+				in.Spec.Mode = oldRedis.Spec.Mode
+				return in
+			}); perr != nil {
+				log.Errorf("failed to revert spec.mode for Redis %s/%s after a rejected transition: %v", newRedis.Namespace, newRedis.Name, perr)
+			}
+			return
+		}
+	}
+	g.ResourceEventHandler.OnUpdate(oldObj, newObj)
+}
+
+// initRedisUserWatcher wires up the informer/queue pair that reconciles
+// RedisUser objects, independent of the Redis queue above: a RedisUser can
+// be edited without touching its target Redis at all, so it needs its own
+// work queue rather than piggy-backing on runRedis.
+func (c *Controller) initRedisUserWatcher() {
+	c.rdUserInformer = c.KubedbInformerFactory.Kubedb().V1alpha2().RedisUsers().Informer()
+	c.rdUserQueue = queue.New("RedisUser", c.MaxNumRequeues, c.NumThreads, c.runRedisUser)
+	c.rdUserLister = c.KubedbInformerFactory.Kubedb().V1alpha2().RedisUsers().Lister()
+	c.rdUserInformer.AddEventHandler(queue.NewChangeHandler(c.rdUserQueue.GetQueue()))
 }
 
 func (c *Controller) runRedis(key string) error {
@@ -59,17 +107,17 @@ func (c *Controller) runRedis(key string) error {
 					log.Errorln(err)
 					return err
 				}
-				_, _, err = util.PatchRedis(context.TODO(), c.DBClient.KubedbV1alpha2(), redis, func(in *api.Redis) *api.Redis {
+				_, err = c.patchRedisWithRetry(redis, func(in *api.Redis) *api.Redis {
 					in.ObjectMeta = core_util.RemoveFinalizer(in.ObjectMeta, kubedb.GroupName)
 					return in
-				}, metav1.PatchOptions{})
+				})
 				return err
 			}
 		} else {
-			redis, _, err = util.PatchRedis(context.TODO(), c.DBClient.KubedbV1alpha2(), redis, func(in *api.Redis) *api.Redis {
+			redis, err = c.patchRedisWithRetry(redis, func(in *api.Redis) *api.Redis {
 				in.ObjectMeta = core_util.AddFinalizer(in.ObjectMeta, kubedb.GroupName)
 				return in
-			}, metav1.PatchOptions{})
+			})
 			if err != nil {
 				return err
 			}
@@ -108,6 +156,11 @@ func (c *Controller) initSecretWatcher() {
 		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
 			if secret, ok := newObj.(*core.Secret); ok {
 				if key := c.RedisForSecret(secret); key != "" {
+					// A cert secret rotating (cert-manager renewal) reaches
+					// here too; maybeRefreshTLSCertsInPlace (tls_rotation.go)
+					// tells that apart from any other secret change by
+					// recomputing computeTLSCertHash on every reconcile, so
+					// this handler doesn't need to flag it separately.
 					queue.Enqueue(c.rdQueue.GetQueue(), key)
 				}
 			}